@@ -0,0 +1,106 @@
+package zrpc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimit 表示自适应并发限制的配置，字段为 0 时使用默认值
+type AdaptiveLimit struct {
+	InitialLimit int     // 初始并发上限，默认 20
+	MinLimit     int     // 并发上限的下限，默认 1
+	MaxLimit     int     // 并发上限的上限，默认 1000
+	Tolerance    float64 // 短期延迟超过基线的该倍数时开始减小并发上限，默认 2
+	Smoothing    float64 // 每次调整并发上限的平滑系数，取值 (0, 1]，默认 0.2
+}
+
+var errAdaptiveLimited = &Error{Code: CodeOverloaded, Message: "rpc server: adaptive concurrency limit exceeded"}
+
+const (
+	shortRTTAlpha = 0.2  // 短期延迟 EWMA 的权重
+	longRTTAlpha  = 0.01 // 长期延迟 EWMA 的权重，长期延迟作为基线
+)
+
+// 基于梯度的自适应并发限制器：比较处理请求的短期延迟与长期基线，
+// 延迟上升时按比例减小并发上限，延迟平稳且并发接近上限时逐步增大并发上限
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	opt      AdaptiveLimit
+	limit    float64 // 当前并发上限
+	inflight int     // 正在处理的请求数
+	shortRTT float64 // 短期延迟的 EWMA，单位为纳秒
+	longRTT  float64 // 长期延迟的 EWMA，单位为纳秒
+}
+
+func newAdaptiveLimiter(opt AdaptiveLimit) *adaptiveLimiter {
+	if opt.InitialLimit <= 0 {
+		opt.InitialLimit = 20
+	}
+	if opt.MinLimit <= 0 {
+		opt.MinLimit = 1
+	}
+	if opt.MaxLimit <= 0 {
+		opt.MaxLimit = 1000
+	}
+	if opt.Tolerance <= 0 {
+		opt.Tolerance = 2
+	}
+	if opt.Smoothing <= 0 || opt.Smoothing > 1 {
+		opt.Smoothing = 0.2
+	}
+	return &adaptiveLimiter{opt: opt, limit: float64(opt.InitialLimit)}
+}
+
+// 申请执行名额，正在处理的请求数达到并发上限时返回错误
+func (l *adaptiveLimiter) acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return errAdaptiveLimited
+	}
+	l.inflight++
+	return nil
+}
+
+// 请求未被处理时归还执行名额，不调整并发上限
+func (l *adaptiveLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+}
+
+// 归还执行名额，并根据本次处理的延迟调整并发上限
+func (l *adaptiveLimiter) release(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	sample := float64(rtt)
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = sample, sample
+		return
+	}
+	l.shortRTT = l.shortRTT*(1-shortRTTAlpha) + sample*shortRTTAlpha
+	l.longRTT = l.longRTT*(1-longRTTAlpha) + sample*longRTTAlpha
+	// 过载结束后基线可能远高于当前延迟，使其更快地回落
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+	// 梯度小于 1 表示延迟高于基线的容忍范围，并发上限按比例减小
+	gradient := math.Max(0.5, math.Min(1, l.opt.Tolerance*l.longRTT/l.shortRTT))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	// 并发远未达到上限时不增大上限，避免低负载时上限无限增长
+	if newLimit > l.limit && float64(inflight) < l.limit/2 {
+		return
+	}
+	newLimit = l.limit*(1-l.opt.Smoothing) + newLimit*l.opt.Smoothing
+	l.limit = math.Max(float64(l.opt.MinLimit), math.Min(float64(l.opt.MaxLimit), newLimit))
+}
+
+// 当前的并发上限与正在处理的请求数
+func (l *adaptiveLimiter) state() (limit, inflight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit), l.inflight
+}
@@ -0,0 +1,31 @@
+package zrpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(AdaptiveLimit{InitialLimit: 10, MinLimit: 2})
+	// 延迟平稳时并发上限不减小
+	for i := 0; i < 50; i++ {
+		_assert(l.acquire() == nil, "expect acquired")
+		l.release(time.Millisecond)
+	}
+	before, _ := l.state()
+	_assert(before >= 10, "expect limit not decreased under stable latency, but got %d", before)
+
+	// 延迟显著上升后并发上限减小
+	for i := 0; i < 50; i++ {
+		_assert(l.acquire() == nil, "expect acquired")
+		l.release(time.Millisecond * 50)
+	}
+	after, _ := l.state()
+	_assert(after < before, "expect limit decreased under rising latency, but got %d -> %d", before, after)
+
+	// 达到并发上限后拒绝请求
+	for i := 0; i < after; i++ {
+		_assert(l.acquire() == nil, "expect acquired")
+	}
+	_assert(l.acquire() != nil, "expect rejected above limit %d", after)
+}
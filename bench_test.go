@@ -0,0 +1,95 @@
+package zrpc_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"zrpc"
+	"zrpc/codec"
+	"zrpc/xclient"
+)
+
+// 压测的请求与响应，与 cmd/zrpc-bench 一致
+type Payload struct {
+	Data []byte
+}
+
+type Bench int
+
+func (b Bench) Echo(args Payload, reply *Payload) error {
+	*reply = args
+	return nil
+}
+
+func startBenchServer(b *testing.B) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = l.Close() })
+	server := zrpc.NewServer()
+	var bench Bench
+	_ = server.Register(&bench)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+// 按编码、消息体大小、连接数与客户端类型组合压测 Bench.Echo，并发度由 -cpu 控制
+func BenchmarkEcho(b *testing.B) {
+	w := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(w)
+	addr := startBenchServer(b)
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		for _, size := range []int{64, 4096} {
+			for _, conns := range []int{1, 4} {
+				for _, useXClient := range []bool{false, true} {
+					name := fmt.Sprintf("codec=%s/size=%d/conns=%d/xclient=%v", strings.TrimPrefix(string(ct), "application/"), size, conns, useXClient)
+					b.Run(name, func(b *testing.B) {
+						benchmarkEcho(b, addr, ct, size, conns, useXClient)
+					})
+				}
+			}
+		}
+	}
+}
+
+func benchmarkEcho(b *testing.B, addr string, ct codec.Type, size, conns int, useXClient bool) {
+	opt := &zrpc.Option{CodecType: ct}
+	var callers []zrpc.Caller
+	if useXClient {
+		xc := xclient.NewXClient(xclient.NewMultiServerDiscovery([]string{addr}), xclient.RandomSelect, opt)
+		xc.SetPool(&xclient.PoolOption{Size: conns})
+		defer func() { _ = xc.Close() }()
+		callers = append(callers, xc)
+	} else {
+		for i := 0; i < conns; i++ {
+			c, err := zrpc.XDial(addr, opt)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() { _ = c.Close() }()
+			callers = append(callers, c)
+		}
+	}
+	args := &Payload{Data: make([]byte, size)}
+	var next uint64
+	b.SetBytes(int64(size) * 2)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c := callers[atomic.AddUint64(&next, 1)%uint64(len(callers))]
+		var reply Payload
+		for pb.Next() {
+			if err := c.Call(context.Background(), "Bench.Echo", args, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
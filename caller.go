@@ -0,0 +1,17 @@
+package zrpc
+
+import "context"
+
+// Caller 是发起 RPC 调用的最小接口，*Client 与 *xclient.XClient 均实现了该接口
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+var _ Caller = (*Client)(nil)
+
+// 以类型化的方式调用 serviceMethod，返回值由 Invoke 分配，无需调用方手动创建
+func Invoke[Req, Resp any](ctx context.Context, c Caller, serviceMethod string, req Req) (Resp, error) {
+	var resp Resp
+	err := c.Call(ctx, serviceMethod, req, &resp)
+	return resp, err
+}
@@ -0,0 +1,19 @@
+package zrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInvoke(t *testing.T) {
+	addr := startServer(t, nil)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	sum, err := Invoke[Args, int](context.Background(), client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	_assert(err == nil && sum == 3, "expect 3, but got %d, %v", sum, err)
+	_, err = Invoke[*Args, int](context.Background(), client, "Foo.Missing", &Args{})
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect error, but got %v", err)
+}
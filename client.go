@@ -8,6 +8,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 	"context"
 	"net/http"
@@ -24,6 +25,7 @@ type Call struct {
 	Args          interface{} // 函数所需参数
 	Reply         interface{} // 函数返回值
 	Error         error       // 发生错误时，设置该值
+	Metadata      map[string]string // 随请求发送的元数据
 	Done          chan *Call  // 支持异步调用
 }
 
@@ -43,12 +45,17 @@ type Client struct {
 	pending  map[uint64]*Call  // 存储未处理完的请求编号，键是编号，值是 Call 实例
 	closing  bool              // 用户主动关闭客户端
 	shutdown bool              // 运行出现错误，导致客户端不可用
+	draining bool              // 不再接受新的请求，所有未完成的请求结束后关闭
+	lastRecv int64             // 最后一次收到数据的时间（UnixNano），原子访问
+	cause    error             // 主动断开连接的原因，用于通知未完成的请求
 }
 
 var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+var ErrKeepaliveTimeout = errors.New("rpc client: keepalive timeout, connection is dead")
+
 // 关闭客户端连接
 func (client *Client) Close() error {
 	client.mu.Lock()
@@ -64,14 +71,40 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.draining
+}
+
+// 优雅关闭客户端：不再接受新的请求，待所有未完成的请求结束后关闭连接
+func (client *Client) Drain() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.draining = true
+	client.closeIfDrained()
+}
+
+// 处于 draining 状态且没有未完成的请求时关闭连接，调用方需持有 client.mu
+func (client *Client) closeIfDrained() {
+	if client.draining && !client.closing && len(client.pending) == 0 {
+		client.closing = true
+		_ = client.cc.Close()
+	}
+}
+
+// 返回尚未完成的请求数量，供负载均衡参考
+func (client *Client) NumPending() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
 }
 
 // 将参数 call 添加到 client.pending 中，并更新 client.seq
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if client.closing || client.shutdown || client.draining {
+		if client.cause != nil {
+			return 0, client.cause
+		}
 		return 0, ErrShutdown
 	}
 	call.Seq = client.seq
@@ -96,9 +129,10 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
 		call.Error = err
 		call.done()
+		delete(client.pending, seq)
 	}
 }
 
@@ -110,6 +144,13 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		atomic.StoreInt64(&client.lastRecv, time.Now().UnixNano())
+		// 服务端通知不再在该连接上发起新的请求
+		if h.ServiceMethod == goAwayServiceMethod {
+			err = client.cc.ReadBody(nil)
+			client.goAway(h.Error)
+			continue
+		}
 
 		call := client.removeCall(h.Seq)
 		switch {
@@ -118,7 +159,7 @@ func (client *Client) receive() {
 			err = client.cc.ReadBody(nil)
 		// call 存在，但服务端处理出错
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = headerError(&h)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		// call 存在，服务端处理正常，那么需要从 body 中读取 Reply 的值
@@ -129,10 +170,64 @@ func (client *Client) receive() {
 			}
 			call.done()
 		}
+		// 读取完响应后，再检查是否需要关闭处于 draining 状态的连接
+		client.mu.Lock()
+		client.closeIfDrained()
+		client.mu.Unlock()
+	}
+	// 若连接是被主动断开的，则使用断开的原因通知未完成的请求
+	client.mu.Lock()
+	if client.cause != nil {
+		err = client.cause
 	}
+	client.mu.Unlock()
 	client.terminateCalls(err)
 }
 
+// 处理服务端的 GOAWAY：连接被拒绝时以拒绝原因结束所有请求，否则在未完成的请求结束后关闭连接
+func (client *Client) goAway(reason string) {
+	if reason == "" {
+		client.Drain()
+		return
+	}
+	client.mu.Lock()
+	client.cause = errors.New(reason)
+	client.mu.Unlock()
+	_ = client.cc.Close()
+}
+
+// 连接空闲时定期发送心跳，超时未收到回复则断开连接，所有未完成的请求以 ErrKeepaliveTimeout 结束
+func (client *Client) keepalive() {
+	interval, timeout := client.opt.KeepaliveInterval, client.opt.KeepaliveTimeout
+	if timeout == 0 {
+		timeout = interval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&client.lastRecv)))
+		if idle < interval {
+			continue
+		}
+		call := client.Go(pingServiceMethod, invalidRequest, nil, make(chan *Call, 1))
+		select {
+		case <-call.Done:
+			if call.Error != nil && !client.IsAvailable() {
+				// 客户端已关闭或不可用
+				return
+			}
+		case <-time.After(timeout):
+			client.removeCall(call.Seq)
+			client.mu.Lock()
+			client.cause = ErrKeepaliveTimeout
+			client.mu.Unlock()
+			// 关闭连接后 receive 将结束，并通知所有未完成的请求
+			_ = client.cc.Close()
+			return
+		}
+	}
+}
+
 // 创建 Client 实例
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	// 指定编解码函数
@@ -159,8 +254,12 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		opt:     opt,  
 		pending: make(map[uint64]*Call),
 	}
+	client.lastRecv = time.Now().UnixNano()
 	// 创建一个子协程调用 receive() 接收响应
 	go client.receive()
+	if opt.KeepaliveInterval > 0 {
+		go client.keepalive()
+	}
 	return client
 }
 
@@ -172,12 +271,13 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
+	// 复制一份，XClient 等调用方会在多个协程中并发使用同一个 Option 建立连接
+	opt := *opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	return &opt, nil
 }
 
 // 客户端向服务端发送请求
@@ -196,6 +296,7 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.Metadata
 	// 编码并发送请求
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
 		call := client.removeCall(seq)
@@ -226,13 +327,23 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 	return call
 }
 
-// 调用 client.Go，并等待其完成
+// 发起调用并等待其完成，ctx 中通过 WithMetadata 设置的元数据将随请求发送
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      MetadataFromContext(ctx),
+		Done:          make(chan *Call, 1),
+	}
+	client.send(call)
 	select {
 	// 达到 context.WithTimeout 设置的超时时间
 	case <-ctx.Done():
 		client.removeCall(call.Seq)
+		client.mu.Lock()
+		client.closeIfDrained()
+		client.mu.Unlock()
 		return errors.New("rpc client: call failed: " + ctx.Err().Error())
 	// 调用结束
 	case call := <-call.Done:
@@ -247,6 +358,26 @@ type clientResult struct {
 
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
+// 建立连接的函数，timeout 为 0 时表示不限时
+type DialFunc func(address string, timeout time.Duration) (net.Conn, error)
+
+// 以传输协议为键的自定义建立连接函数
+var dialers sync.Map
+
+// 为传输协议 network 注册建立连接的函数，Dial 与 XDial 将通过 dial 建立该协议的连接，
+// 用于进程内连接等 net.Dial 不支持的传输协议
+func RegisterDialer(network string, dial DialFunc) {
+	dialers.Store(network, dial)
+}
+
+// 建立网络连接，优先使用为 network 注册的建立连接函数
+func dialConn(network, address string, timeout time.Duration) (net.Conn, error) {
+	if dial, ok := dialers.Load(network); ok {
+		return dial.(DialFunc)(address, timeout)
+	}
+	return net.DialTimeout(network, address, timeout)
+}
+
 func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
@@ -255,7 +386,7 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	// 如果连接创建超时，将返回错误
 	// 此处连接创建主要包括：1. 解析参数 network 和 address 的值
 	// 2. 创建 socket 实例并建立网络连接
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	conn, err := dialConn(network, address, opt.ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
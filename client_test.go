@@ -0,0 +1,76 @@
+package zrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+	"zrpc/codec"
+)
+
+func TestClient_Keepalive(t *testing.T) {
+	// 只接收数据、从不回复的服务端，模拟半开连接
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_, _ = io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{
+		KeepaliveInterval: time.Millisecond * 50,
+		KeepaliveTimeout:  time.Millisecond * 50,
+	})
+	_assert(err == nil, "dial error: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply int
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == ErrKeepaliveTimeout, "expect keepalive timeout, but got %v", err)
+	_assert(!client.IsAvailable(), "expect client unavailable after keepalive timeout")
+}
+
+func TestClient_KeepaliveAlive(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{
+		KeepaliveInterval: time.Millisecond * 20,
+		KeepaliveTimeout:  time.Millisecond * 100,
+	})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	time.Sleep(time.Millisecond * 150)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+}
+
+func TestClient_JsonCodec(t *testing.T) {
+	addr := startServer(t, nil)
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.JsonType})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+	// 参数与返回值可以是原始 JSON，无需编译期类型
+	var raw json.RawMessage
+	err = client.Call(context.Background(), "Foo.Sum", json.RawMessage(`{"Num1":3,"Num2":4}`), &raw)
+	_assert(err == nil && string(raw) == "7", "expect 7, but got %s, %v", raw, err)
+	// 出错后连接仍然可用
+	err = client.Call(context.Background(), "Foo.Missing", &Args{}, &reply)
+	_assert(err != nil, "expect error for missing method")
+	services, err := ListServices(context.Background(), client)
+	_assert(err == nil && len(services) == 2, "expect 2 services, but got %v, %v", services, err)
+}
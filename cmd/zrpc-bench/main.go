@@ -0,0 +1,276 @@
+// zrpc-bench 对 zrpc 进行压测，报告 QPS 与延迟分布。
+//
+// 未指定 -addr 时在进程内启动一个发布了 Bench.Echo 的服务端；指定 -addr 时压测已有的服务端，
+// 被压测的方法需接收并返回 Payload（或结构相同的类型）。
+//
+// 用法：
+//
+//	zrpc-bench -c 64 -n 100000 -size 1024 -codec gob -conns 4
+//	zrpc-bench -xclient -d 30s -addr tcp@127.0.0.1:9999
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zrpc"
+	"zrpc/codec"
+	"zrpc/xclient"
+)
+
+// 压测的请求与响应
+type Payload struct {
+	Data []byte
+}
+
+type Bench int
+
+// 原样返回请求
+func (b Bench) Echo(args Payload, reply *Payload) error {
+	*reply = args
+	return nil
+}
+
+type config struct {
+	addr        string
+	method      string
+	concurrency int
+	requests    int
+	duration    time.Duration
+	size        int
+	codec       codec.Type
+	conns       int
+	xclient     bool
+	timeout     time.Duration
+}
+
+func main() {
+	var cfg config
+	var codecName string
+	flag.StringVar(&cfg.addr, "addr", "", "target server address such as tcp@127.0.0.1:9999; empty starts an in-process server")
+	flag.StringVar(&cfg.method, "method", "Bench.Echo", "method that echoes a Payload")
+	flag.IntVar(&cfg.concurrency, "c", 16, "number of concurrent callers")
+	flag.IntVar(&cfg.requests, "n", 100000, "total number of requests, ignored when -d is set")
+	flag.DurationVar(&cfg.duration, "d", 0, "run for this duration instead of -n requests")
+	flag.IntVar(&cfg.size, "size", 128, "payload size in bytes")
+	flag.StringVar(&codecName, "codec", "gob", "codec: gob or json")
+	flag.IntVar(&cfg.conns, "conns", 1, "number of connections")
+	flag.BoolVar(&cfg.xclient, "xclient", false, "call through XClient with a pool of -conns connections instead of Client")
+	flag.DurationVar(&cfg.timeout, "timeout", time.Second*10, "timeout of each call")
+	flag.Parse()
+	switch codecName {
+	case "gob":
+		cfg.codec = codec.GobType
+	case "json":
+		cfg.codec = codec.JsonType
+	default:
+		fmt.Fprintln(os.Stderr, "zrpc-bench: unknown codec", codecName)
+		os.Exit(2)
+	}
+	if cfg.concurrency < 1 || cfg.conns < 1 {
+		fmt.Fprintln(os.Stderr, "zrpc-bench: -c and -conns must be positive")
+		os.Exit(2)
+	}
+	// 压测期间只输出报告
+	log.SetOutput(ioutil.Discard)
+	if cfg.addr == "" {
+		addr, err := startServer()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "zrpc-bench:", err)
+			os.Exit(1)
+		}
+		cfg.addr = addr
+	}
+	r, err := run(&cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zrpc-bench:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("target:      %s %s (codec %s, %d conns, concurrency %d, payload %dB, xclient %v)\n",
+		cfg.addr, cfg.method, codecName, cfg.conns, cfg.concurrency, cfg.size, cfg.xclient)
+	r.print(os.Stdout)
+}
+
+// 在进程内启动一个发布了 Bench.Echo 的服务端
+func startServer() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	server := zrpc.NewServer()
+	var b Bench
+	if err := server.Register(&b); err != nil {
+		return "", err
+	}
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String(), nil
+}
+
+// 建立 cfg.conns 个连接，返回每个调用方使用的 Caller 及关闭函数
+func dial(cfg *config) (callers []zrpc.Caller, closeAll func(), err error) {
+	opt := &zrpc.Option{CodecType: cfg.codec, ConnectTimeout: cfg.timeout}
+	if cfg.xclient {
+		xc := xclient.NewXClient(xclient.NewMultiServerDiscovery([]string{cfg.addr}), xclient.RandomSelect, opt)
+		xc.SetPool(&xclient.PoolOption{Size: cfg.conns})
+		return []zrpc.Caller{xc}, func() { _ = xc.Close() }, nil
+	}
+	var clients []*zrpc.Client
+	closeAll = func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}
+	for i := 0; i < cfg.conns; i++ {
+		c, err := zrpc.XDial(cfg.addr, opt)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		clients = append(clients, c)
+		callers = append(callers, c)
+	}
+	return callers, closeAll, nil
+}
+
+// 执行压测，调用方轮流使用已建立的连接
+func run(cfg *config) (*result, error) {
+	callers, closeAll, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll()
+	args := Payload{Data: []byte(strings.Repeat("x", cfg.size))}
+	var (
+		issued   int64
+		deadline time.Time
+		wg       sync.WaitGroup
+	)
+	if cfg.duration > 0 {
+		deadline = time.Now().Add(cfg.duration)
+	}
+	// 未到截止时间或请求数未达到上限时继续调用
+	next := func() bool {
+		if cfg.duration > 0 {
+			return time.Now().Before(deadline)
+		}
+		return atomic.AddInt64(&issued, 1) <= int64(cfg.requests)
+	}
+	workers := make([]*result, cfg.concurrency)
+	start := time.Now()
+	for i := range workers {
+		w := &result{}
+		workers[i] = w
+		c := callers[i%len(callers)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next() {
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+				var reply Payload
+				begin := time.Now()
+				err := c.Call(ctx, cfg.method, &args, &reply)
+				w.record(time.Since(begin), err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	r := &result{elapsed: time.Since(start)}
+	for _, w := range workers {
+		r.merge(w)
+	}
+	return r, nil
+}
+
+// 压测结果
+type result struct {
+	elapsed   time.Duration
+	latencies []time.Duration
+	errors    int
+	firstErr  error
+}
+
+func (r *result) record(d time.Duration, err error) {
+	if err != nil {
+		r.errors++
+		if r.firstErr == nil {
+			r.firstErr = err
+		}
+		return
+	}
+	r.latencies = append(r.latencies, d)
+}
+
+func (r *result) merge(o *result) {
+	r.latencies = append(r.latencies, o.latencies...)
+	r.errors += o.errors
+	if r.firstErr == nil {
+		r.firstErr = o.firstErr
+	}
+}
+
+// 返回第 p 百分位的延迟，latencies 需已排序
+func (r *result) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	}
+	return r.latencies[i]
+}
+
+func (r *result) print(w io.Writer) {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	total := len(r.latencies) + r.errors
+	fmt.Fprintf(w, "requests:    %d (%d failed) in %s\n", total, r.errors, r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "qps:         %.1f\n", float64(len(r.latencies))/r.elapsed.Seconds())
+	if r.firstErr != nil {
+		fmt.Fprintf(w, "first error: %v\n", r.firstErr)
+	}
+	if len(r.latencies) == 0 {
+		return
+	}
+	var sum time.Duration
+	for _, d := range r.latencies {
+		sum += d
+	}
+	fmt.Fprintf(w, "latency:     mean %s, max %s\n", sum/time.Duration(len(r.latencies)), r.latencies[len(r.latencies)-1])
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "  p%-5v     %s\n", p, r.percentile(p))
+	}
+	// 按 2 的幂划分的延迟直方图
+	fmt.Fprintln(w, "histogram:")
+	buckets := make(map[int]int)
+	low, high := 64, 0
+	for _, d := range r.latencies {
+		b := bits.Len64(uint64(d / time.Microsecond))
+		buckets[b]++
+		if b < low {
+			low = b
+		}
+		if b > high {
+			high = b
+		}
+	}
+	for b := low; b <= high; b++ {
+		upper := time.Microsecond << uint(b)
+		pct := float64(buckets[b]) * 100 / float64(len(r.latencies))
+		fmt.Fprintf(w, "  < %-10s %8d %6.2f%% %s\n", upper, buckets[b], pct, strings.Repeat("#", int(pct/2)))
+	}
+}
@@ -0,0 +1,213 @@
+// zrpc 是调用 zrpc 服务的命令行客户端，参数与返回值均以 JSON 表示。
+//
+// 用法：
+//
+//	zrpc [flags] <address> list [Service]
+//	zrpc [flags] <address> describe Service.Method
+//	zrpc [flags] <address> call Service.Method [json-args]
+//
+// address 可以是 XDial 支持的地址（如 tcp@127.0.0.1:9999、http@127.0.0.1:9999），
+// 也可以是注册中心的 URL（如 http://127.0.0.1:9999/_zrpc_/registry）。
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zrpc"
+	"zrpc/codec"
+	"zrpc/xclient"
+)
+
+// 可重复指定的 key=value 元数据
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New("metadata must be key=value")
+	}
+	m[kv[0]] = kv[1]
+	return nil
+}
+
+func main() {
+	md := metadataFlag{}
+	timeout := flag.Duration("timeout", time.Second*10, "timeout of each call")
+	repeat := flag.Int("n", 1, "number of calls")
+	concurrency := flag.Int("c", 1, "number of concurrent callers")
+	flag.Var(md, "md", "request metadata key=value, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n")
+		fmt.Fprintf(os.Stderr, "  zrpc [flags] <address> list [Service]\n")
+		fmt.Fprintf(os.Stderr, "  zrpc [flags] <address> describe Service.Method\n")
+		fmt.Fprintf(os.Stderr, "  zrpc [flags] <address> call Service.Method [json-args]\n")
+		fmt.Fprintf(os.Stderr, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	xc := newXClient(args[0], *timeout)
+	defer func() { _ = xc.Close() }()
+
+	ctx := context.Background()
+	if len(md) > 0 {
+		ctx = zrpc.WithMetadata(ctx, md)
+	}
+	var err error
+	switch cmd := args[1]; {
+	case cmd == "list" && len(args) <= 3:
+		name := ""
+		if len(args) == 3 {
+			name = args[2]
+		}
+		err = list(ctx, xc, name, *timeout)
+	case cmd == "describe" && len(args) == 3:
+		err = describe(ctx, xc, args[2], *timeout)
+	case cmd == "call" && (len(args) == 3 || len(args) == 4):
+		body := "null"
+		if len(args) == 4 {
+			body = args[3]
+		}
+		err = call(ctx, xc, args[2], body, *timeout, *repeat, *concurrency)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zrpc:", err)
+		os.Exit(1)
+	}
+}
+
+// 根据地址创建使用 JSON 编码的 XClient，地址以 http:// 或 https:// 开头时视为注册中心
+func newXClient(addr string, timeout time.Duration) *xclient.XClient {
+	var d xclient.Discovery
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		d = xclient.NewZRegistryDiscovery(addr, 0)
+	} else {
+		d = xclient.NewMultiServerDiscovery([]string{addr})
+	}
+	return xclient.NewXClient(d, xclient.RandomSelect, &zrpc.Option{
+		CodecType:      codec.JsonType,
+		ConnectTimeout: timeout,
+	})
+}
+
+func list(ctx context.Context, c zrpc.Caller, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	services, err := zrpc.Invoke[string, []zrpc.ServiceInfo](ctx, c, "_zrpc.ListServices", name)
+	if err != nil {
+		return err
+	}
+	for _, svc := range services {
+		for _, m := range svc.Methods {
+			fmt.Println(svc.Name + "." + m)
+		}
+	}
+	return nil
+}
+
+func describe(ctx context.Context, c zrpc.Caller, serviceMethod string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	info, err := zrpc.DescribeMethod(ctx, c, serviceMethod)
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+// 调用 n 次 serviceMethod，最多 concurrency 个调用同时进行。只调用一次时格式化输出返回值，
+// 否则每行输出一个返回值，并在标准错误输出统计信息
+func call(ctx context.Context, c zrpc.Caller, serviceMethod, body string, timeout time.Duration, n, concurrency int) error {
+	args := json.RawMessage(body)
+	if !json.Valid(args) {
+		return errors.New("args is not valid JSON: " + body)
+	}
+	if n <= 1 {
+		reply, err := invoke(ctx, c, serviceMethod, args, timeout)
+		if err != nil {
+			return err
+		}
+		return printJSON(reply)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu       sync.Mutex
+		next     int64
+		failures int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&next, 1) <= int64(n) {
+				reply, err := invoke(ctx, c, serviceMethod, args, timeout)
+				mu.Lock()
+				if err != nil {
+					failures++
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					fmt.Println(string(reply))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	fmt.Fprintf(os.Stderr, "%d calls, %d failed, %s, %.1f calls/s\n",
+		n, failures, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+	if firstErr != nil {
+		return fmt.Errorf("first error: %v", firstErr)
+	}
+	return nil
+}
+
+func invoke(ctx context.Context, c zrpc.Caller, serviceMethod string, args json.RawMessage, timeout time.Duration) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return zrpc.Invoke[json.RawMessage, json.RawMessage](ctx, c, serviceMethod, args)
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
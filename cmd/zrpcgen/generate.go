@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// 接口中的一个 RPC 方法
+type method struct {
+	Name     string
+	Ctx      bool   // 第一个参数为 context.Context
+	Args     string // 参数类型
+	ArgsElem string // 参数为指针时指向的类型，否则为空
+	Reply    string // 返回值指针指向的类型
+	NewReply string // 新建返回值的表达式，map 与切片需要初始化
+}
+
+// 一个 RPC 接口
+type service struct {
+	Name    string
+	Methods []method
+}
+
+type file struct {
+	Source   string
+	Package  string
+	Imports  []string // 参数与返回值类型引用的包，已带引号与别名
+	ZrpcPath string
+	Services []service
+}
+
+// 解析 src 中名为 names 的接口（为空时解析全部接口），生成客户端与服务端代码
+func generate(filename string, src []byte, names []string, zrpcPath string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+	g := &generator{fset: fset, imports: fileImports(f), used: make(map[string]bool), decls: fileTypes(f)}
+	out := &file{Source: filename, Package: f.Name.Name, ZrpcPath: zrpcPath}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			it, ok := ts.Type.(*ast.InterfaceType)
+			if !ok || (len(want) > 0 && !want[ts.Name.Name]) {
+				continue
+			}
+			svc, err := g.service(ts.Name.Name, it)
+			if err != nil {
+				return nil, err
+			}
+			out.Services = append(out.Services, svc)
+			delete(want, ts.Name.Name)
+		}
+	}
+	for name := range want {
+		return nil, fmt.Errorf("interface %s not found in %s", name, filename)
+	}
+	if len(out.Services) == 0 {
+		return nil, fmt.Errorf("no interface found in %s", filename)
+	}
+	for name := range g.used {
+		// 生成的代码已导入 context 与 errors
+		if imp := g.imports[name]; imp != `"context"` && imp != `"errors"` {
+			out.Imports = append(out.Imports, imp)
+		}
+	}
+	sort.Strings(out.Imports)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, out); err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return code, nil
+}
+
+type generator struct {
+	fset    *token.FileSet
+	imports map[string]string   // 包名到 import 声明的映射
+	used    map[string]bool     // 参数与返回值类型引用的包名
+	decls   map[string]ast.Expr // 文件中声明的类型名到其定义的映射
+}
+
+// 解析接口的所有方法
+func (g *generator) service(name string, it *ast.InterfaceType) (service, error) {
+	svc := service{Name: name}
+	for _, field := range it.Methods.List {
+		if len(field.Names) == 0 {
+			return svc, fmt.Errorf("%s: embedded interfaces are not supported", name)
+		}
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok {
+			return svc, fmt.Errorf("%s: unsupported interface element", name)
+		}
+		m, err := g.method(field.Names[0].Name, ft)
+		if err != nil {
+			return svc, fmt.Errorf("%s.%s: %v", name, field.Names[0].Name, err)
+		}
+		svc.Methods = append(svc.Methods, m)
+	}
+	if len(svc.Methods) == 0 {
+		return svc, fmt.Errorf("%s has no methods", name)
+	}
+	return svc, nil
+}
+
+// 检查方法是否符合 (args T1, reply *T2) error 或 (ctx context.Context, args T1, reply *T2) error 的形式
+func (g *generator) method(name string, ft *ast.FuncType) (method, error) {
+	m := method{Name: name}
+	if !ast.IsExported(name) {
+		return m, errors.New("method is not exported")
+	}
+	if name == "NewArgs" || name == "Handle" {
+		return m, errors.New("method name is reserved by zrpc.Handler")
+	}
+	var params []ast.Expr
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	if len(params) == 3 && g.isContext(params[0]) {
+		m.Ctx = true
+		params = params[1:]
+	}
+	if len(params) != 2 {
+		return m, fmt.Errorf("has %d args, want 2 or a context.Context followed by 2", len(params))
+	}
+	if ft.Results == nil || len(ft.Results.List) != 1 || len(ft.Results.List[0].Names) > 1 {
+		return m, errors.New("must return exactly one error")
+	}
+	if ident, ok := ft.Results.List[0].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return m, errors.New("must return error")
+	}
+	reply, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return m, errors.New("reply type is not a pointer")
+	}
+	// 与注册时的校验一致，提前报告未导出的类型
+	if !g.isExportedOrBuiltin(params[0]) {
+		return m, fmt.Errorf("arg type %s is not exported", g.typeString(params[0]))
+	}
+	if !g.isExportedOrBuiltin(reply) {
+		return m, fmt.Errorf("reply type %s is not exported", g.typeString(reply.X))
+	}
+	m.Args = g.typeString(params[0])
+	if star, ok := params[0].(*ast.StarExpr); ok {
+		m.ArgsElem = g.typeString(star.X)
+	}
+	m.Reply = g.typeString(reply.X)
+	// 与反射调用一致，map 与切片类型的返回值需要初始化，否则方法向 nil map 写入时 panic
+	m.NewReply = "new(" + m.Reply + ")"
+	if g.isMapOrSlice(reply.X, 0) {
+		m.NewReply = "&" + m.Reply + "{}"
+	}
+	return m, nil
+}
+
+// 判断去掉指针后的类型是否为导出类型或内置类型
+func (g *generator) isExportedOrBuiltin(expr ast.Expr) bool {
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.ParenExpr:
+			expr = t.X
+		case *ast.Ident:
+			if ast.IsExported(t.Name) {
+				return true
+			}
+			_, ok := types.Universe.Lookup(t.Name).(*types.TypeName)
+			return ok
+		case *ast.SelectorExpr:
+			return ast.IsExported(t.Sel.Name)
+		default:
+			// 复合类型与反射的 PkgPath 一样视为内置类型
+			return true
+		}
+	}
+}
+
+// 判断类型是否为 map 或切片，只能识别当前文件中声明的类型，depth 用于避免循环定义
+func (g *generator) isMapOrSlice(expr ast.Expr, depth int) bool {
+	switch t := expr.(type) {
+	case *ast.MapType:
+		return true
+	case *ast.ArrayType:
+		return t.Len == nil
+	case *ast.ParenExpr:
+		return g.isMapOrSlice(t.X, depth)
+	case *ast.Ident:
+		if def, ok := g.decls[t.Name]; ok && depth < len(g.decls) {
+			return g.isMapOrSlice(def, depth+1)
+		}
+	}
+	return false
+}
+
+// 判断类型是否为 context.Context
+func (g *generator) isContext(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && sel.Sel.Name == "Context" && g.imports[pkg.Name] == strconv.Quote("context")
+}
+
+// 返回类型的源码表示，并记录其引用的包
+func (g *generator) typeString(expr ast.Expr) string {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok && g.imports[pkg.Name] != "" {
+				g.used[pkg.Name] = true
+			}
+		}
+		return true
+	})
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, g.fset, expr)
+	return buf.String()
+}
+
+// 返回文件中包名到 import 声明的映射
+func fileImports(f *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil {
+			if spec.Name.Name != "_" && spec.Name.Name != "." {
+				imports[spec.Name.Name] = spec.Name.Name + " " + spec.Path.Value
+			}
+			continue
+		}
+		imports[path.Base(p)] = spec.Path.Value
+	}
+	return imports
+}
+
+// 返回文件中声明的类型名到其定义的映射
+func fileTypes(f *ast.File) map[string]ast.Expr {
+	decls := make(map[string]ast.Expr)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			decls[ts.Name.Name] = ts.Type
+		}
+	}
+	return decls
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+var tmpl = template.Must(template.New("zrpc").Funcs(template.FuncMap{"lower": lowerFirst}).Parse(`// Code generated by zrpcgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"errors"
+{{range .Imports}}	{{.}}
+{{end}}
+	zrpc "{{.ZrpcPath}}"
+)
+{{range .Services}}{{$svc := .Name}}{{$handler := printf "%sHandler" (lower .Name)}}
+// {{$svc}}Client 是 {{$svc}} 服务的类型化客户端
+type {{$svc}}Client struct {
+	c zrpc.Caller
+}
+
+// 新建 {{$svc}} 服务的客户端，c 可以是 *zrpc.Client 或 *xclient.XClient
+func New{{$svc}}Client(c zrpc.Caller) *{{$svc}}Client {
+	return &{{$svc}}Client{c: c}
+}
+{{range .Methods}}
+// 调用 {{$svc}}.{{.Name}}
+func (c *{{$svc}}Client) {{.Name}}(ctx context.Context, args {{.Args}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	err := c.c.Call(ctx, "{{$svc}}.{{.Name}}", args, &reply)
+	return reply, err
+}
+{{end}}
+// 以 {{$svc}} 为服务名将 impl 发布到 server，调用不经过反射
+func Register{{$svc}}(server *zrpc.Server, impl {{$svc}}) error {
+	return server.RegisterName("{{$svc}}", &{{$handler}}{impl: impl})
+}
+
+// {{$handler}} 实现了 zrpc.Handler
+type {{$handler}} struct {
+	impl {{$svc}}
+}
+{{range .Methods}}
+func (h *{{$handler}}) {{.Name}}(ctx context.Context, args {{.Args}}, reply *{{.Reply}}) error {
+	return h.impl.{{.Name}}({{if .Ctx}}ctx, {{end}}args, reply)
+}
+{{end}}
+func (h *{{$handler}}) NewArgs(method string) (args, reply interface{}) {
+	switch method {
+{{- range .Methods}}
+	case "{{.Name}}":
+		return new({{if .ArgsElem}}{{.ArgsElem}}{{else}}{{.Args}}{{end}}), {{.NewReply}}
+{{- end}}
+	}
+	return nil, nil
+}
+
+func (h *{{$handler}}) Handle(ctx context.Context, method string, args, reply interface{}) error {
+	switch method {
+{{- range .Methods}}
+	case "{{.Name}}":
+		return h.impl.{{.Name}}({{if .Ctx}}ctx, {{end}}{{if .ArgsElem}}args.(*{{.ArgsElem}}){{else}}*args.(*{{.Args}}){{end}}, reply.(*{{.Reply}}))
+{{- end}}
+	}
+	return errors.New("rpc server: can't find method " + method)
+}
+{{end}}`))
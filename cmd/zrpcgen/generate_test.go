@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const src = `package arith
+
+import (
+	"context"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Arith interface {
+	Sum(args Args, reply *int) error
+	Wait(ctx context.Context, d *time.Duration, reply *[]string) error
+}
+`
+
+func TestGenerate(t *testing.T) {
+	code, err := generate("arith.go", []byte(src), nil, "zrpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"time"`,
+		"func (c *ArithClient) Sum(ctx context.Context, args Args) (int, error)",
+		"func (c *ArithClient) Wait(ctx context.Context, args *time.Duration) ([]string, error)",
+		"func RegisterArith(server *zrpc.Server, impl Arith) error",
+		"return h.impl.Sum(*args.(*Args), reply.(*int))",
+		"return h.impl.Wait(ctx, args.(*time.Duration), reply.(*[]string))",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("expect %q in generated code:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerate_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"Bad":     "type Bad interface { Sum(args int) error }",
+		"NoError": "type NoError interface { Sum(args int, reply *int) int }",
+		"Value":   "type Value interface { Sum(args int, reply int) error }",
+		"Args":    "type args struct{}\ntype Args interface { Sum(args *args, reply *int) error }",
+		"Reply":   "type reply struct{}\ntype Reply interface { Sum(args int, reply *reply) error }",
+	}
+	for name, decl := range cases {
+		if _, err := generate("bad.go", []byte("package bad\n"+decl), nil, "zrpc"); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	if _, err := generate("arith.go", []byte(src), []string{"Missing"}, "zrpc"); err == nil {
+		t.Error("expect error for missing interface")
+	}
+}
+
+const runSrc = `package arith
+
+import "context"
+
+type Args struct{ Num1, Num2 int }
+
+type Counts map[string]int
+
+type Arith interface {
+	Sum(args Args, reply *int) error
+	Count(args *Args, reply *map[string]int) error
+	Tally(args Args, reply *Counts) error
+	Names(ctx context.Context, args int, reply *[]string) error
+}
+
+type arith struct{}
+
+func (arith) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (arith) Count(args *Args, reply *map[string]int) error {
+	(*reply)["sum"] = args.Num1 + args.Num2
+	return nil
+}
+
+func (arith) Tally(args Args, reply *Counts) error {
+	(*reply)["num1"] = args.Num1
+	return nil
+}
+
+func (arith) Names(ctx context.Context, args int, reply *[]string) error {
+	for i := 0; i < args; i++ {
+		*reply = append(*reply, "n")
+	}
+	return nil
+}
+`
+
+const runTest = `package arith
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"zrpc"
+)
+
+func TestArith(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	server := zrpc.NewServer()
+	if err := RegisterArith(server, arith{}); err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	client, err := zrpc.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c := NewArithClient(client)
+	ctx := context.Background()
+	sum, err := c.Sum(ctx, Args{Num1: 1, Num2: 2})
+	if err != nil || sum != 3 {
+		t.Fatalf("Sum: %v, %v", sum, err)
+	}
+	count, err := c.Count(ctx, &Args{Num1: 1, Num2: 2})
+	if err != nil || count["sum"] != 3 {
+		t.Fatalf("Count: %v, %v", count, err)
+	}
+	tally, err := c.Tally(ctx, Args{Num1: 4})
+	if err != nil || tally["num1"] != 4 {
+		t.Fatalf("Tally: %v, %v", tally, err)
+	}
+	names, err := c.Names(ctx, 2)
+	if err != nil || fmt.Sprint(names) != "[n n]" {
+		t.Fatalf("Names: %v, %v", names, err)
+	}
+}
+`
+
+// 编译生成的代码，并通过真实的服务端调用每个方法
+func TestGenerate_Run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go test of generated code in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := generate("arith.go", []byte(runSrc), nil, "zrpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":        "module arith\n\ngo 1.18\n\nrequire zrpc v0.0.0\n\nreplace zrpc => " + root + "\n",
+		"arith.go":      runSrc,
+		"arith_zrpc.go": string(code),
+		"arith_test.go": runTest,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goBin, "test", "-count=1", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test of generated code: %v\n%s\ngenerated code:\n%s", err, out, code)
+	}
+}
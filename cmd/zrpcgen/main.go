@@ -0,0 +1,65 @@
+// zrpcgen 根据 Go 接口定义生成类型化的客户端与服务端注册函数。
+//
+// 接口的每个方法需符合 RPC 方法的形式：
+//
+//	Sum(args Args, reply *int) error
+//	Sum(ctx context.Context, args Args, reply *int) error
+//
+// 参数与返回值需为导出类型或内置类型。返回值为 map 或切片时服务端会先初始化，
+// 其类型需直接写出或在同一文件中声明，其他包中声明的 map 类型无法识别。
+//
+// 用法：
+//
+//	zrpcgen -type Foo foo.go
+//
+// 为接口 Foo 生成 foo_zrpc.go，其中包含：
+//   - FooClient：包装 *zrpc.Client 或 *xclient.XClient，方法名在编译期检查；
+//   - RegisterFoo：以 Foo 为服务名发布实现，调用通过 zrpc.Handler 不经过反射。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("zrpcgen: ")
+	typeNames := flag.String("type", "", "comma-separated interface names; default all interfaces in the file")
+	output := flag.String("o", "", "output file name; default <file>_zrpc.go")
+	zrpcPath := flag.String("zrpc", "zrpc", "import path of the zrpc package")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: zrpcgen [flags] file.go\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+	code, err := generate(filepath.Base(filename), src, types, *zrpcPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(filename, ".go") + "_zrpc.go"
+	}
+	if err := ioutil.WriteFile(out, code, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
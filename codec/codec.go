@@ -7,6 +7,8 @@ type Header struct {
 	ServiceMethod string //服务名和方法名，通常与 Go 中的结构体和方法相映射
 	Seq           uint64 //请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求
 	Error         string //错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	Code          int    //错误码，0 表示未分类的错误
+	Metadata      map[string]string //元数据，请求中由客户端通过 context 设置，响应中由服务端设置
 }
 
 // 抽象出对消息体进行编解码的接口 Codec，以实现不同的 Codec 实例
@@ -24,7 +26,7 @@ type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // 未使用
+	JsonType Type = "application/json"
 )
 
 // 客户端和服务端可以通过 Codec 的 Type 得到构造函数，从而创建 Codec 实例
@@ -33,4 +35,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
\ No newline at end of file
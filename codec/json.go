@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// JsonCodec 以 JSON 编码头部和消息体，便于命令行等不依赖 Go 类型的工具调用服务
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	// body 为 nil 时丢弃消息体
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+
+	if err := c.enc.Encode(h); err != nil {
+		log.Println("rpc codec: json error encoding header:", err)
+		return err
+	}
+
+	if err := c.enc.Encode(body); err != nil {
+		log.Println("rpc codec: json error encoding body:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
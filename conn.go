@@ -0,0 +1,81 @@
+package zrpc
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zrpc/codec"
+)
+
+// 单个连接的状态，用于检查空闲超时与最大存活时间
+type connState struct {
+	lastActive int64         // 最后一次开始或结束处理请求的时间（UnixNano），原子访问
+	inflight   int64         // 正在处理的请求数，原子访问
+	done       chan struct{} // 连接的读循环结束时关闭
+}
+
+func newConnState() *connState {
+	return &connState{lastActive: time.Now().UnixNano(), done: make(chan struct{})}
+}
+
+// 开始处理一个请求
+func (cs *connState) begin() {
+	atomic.AddInt64(&cs.inflight, 1)
+	atomic.StoreInt64(&cs.lastActive, time.Now().UnixNano())
+}
+
+// 结束处理一个请求
+func (cs *connState) end() {
+	atomic.StoreInt64(&cs.lastActive, time.Now().UnixNano())
+	atomic.AddInt64(&cs.inflight, -1)
+}
+
+// 判断连接是否已空闲超过 timeout
+func (cs *connState) idle(timeout time.Duration) bool {
+	return atomic.LoadInt64(&cs.inflight) == 0 &&
+		time.Since(time.Unix(0, atomic.LoadInt64(&cs.lastActive))) >= timeout
+}
+
+// 监视连接：空闲超时后关闭连接；存活超过 MaxConnectionAge 后发送 GOAWAY，
+// 由客户端在未完成的请求结束后关闭连接，超过 MaxConnectionAgeGrace 仍未关闭则强制关闭
+func (server *Server) watchConn(cc codec.Codec, cs *connState, sending *sync.Mutex) {
+	opt := server.opt
+	var idle <-chan time.Time
+	if opt.IdleTimeout > 0 {
+		t := time.NewTicker(opt.IdleTimeout / 2)
+		defer t.Stop()
+		idle = t.C
+	}
+	var age, grace <-chan time.Time
+	if opt.MaxConnectionAge > 0 {
+		t := time.NewTimer(opt.MaxConnectionAge)
+		defer t.Stop()
+		age = t.C
+	}
+	for {
+		select {
+		case <-cs.done:
+			return
+		case <-idle:
+			if cs.idle(opt.IdleTimeout) {
+				log.Println("rpc server: close idle connection")
+				_ = cc.Close()
+				return
+			}
+		case <-age:
+			server.sendResponse(cc, &codec.Header{ServiceMethod: goAwayServiceMethod}, invalidRequest, sending)
+			age = nil
+			if opt.MaxConnectionAgeGrace > 0 {
+				t := time.NewTimer(opt.MaxConnectionAgeGrace)
+				defer t.Stop()
+				grace = t.C
+			}
+		case <-grace:
+			log.Println("rpc server: close connection after max connection age grace")
+			_ = cc.Close()
+			return
+		}
+	}
+}
@@ -4,12 +4,28 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	{{with .Limiters}}
+	<hr>
+	Concurrency
+	<hr>
+		<table>
+		<th align=center>Scope</th><th align=center>Running</th><th align=center>Queued</th>
+		{{range .}}
+			<tr>
+			<td align=left font=fixed>{{.Name}}</td>
+			<td align=center>{{.Running}} / {{.Limit.MaxConcurrent}}</td>
+			<td align=center>{{.Queued}} / {{.Limit.MaxQueued}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
@@ -37,6 +53,18 @@ type debugService struct {
 	Method map[string]*methodType
 }
 
+type debugLimiter struct {
+	Name    string
+	Limit   ConcurrencyLimit
+	Running int
+	Queued  int
+}
+
+type debugPage struct {
+	Limiters []debugLimiter
+	Services []debugService
+}
+
 // Runs at /debug/geerpc
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Build a sorted version of the data.
@@ -45,11 +73,25 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		svc := svci.(*service)
 		services = append(services, debugService{
 			Name:   namei.(string),
-			Method: svc.method,
+			Method: svc.methods(),
 		})
 		return true
 	})
-	err := debug.Execute(w, services)
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	var limiters []debugLimiter
+	for _, l := range server.methodLimiters {
+		limiters = append(limiters, debugLimiter{l.name, l.limit, l.Running(), l.Queued()})
+	}
+	sort.Slice(limiters, func(i, j int) bool { return limiters[i].Name < limiters[j].Name })
+	// 服务端级别的限制器排在最前
+	if l := server.limiter; l != nil {
+		limiters = append([]debugLimiter{{l.name, l.limit, l.Running(), l.Queued()}}, limiters...)
+	}
+	if server.adaptive != nil {
+		limit, inflight := server.adaptive.state()
+		limiters = append([]debugLimiter{{"adaptive", ConcurrencyLimit{MaxConcurrent: limit}, inflight, 0}}, limiters...)
+	}
+	err := debug.Execute(w, debugPage{Limiters: limiters, Services: services})
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
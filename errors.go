@@ -0,0 +1,81 @@
+package zrpc
+
+import (
+	"errors"
+	"time"
+
+	"zrpc/codec"
+)
+
+// 错误码，通过 codec.Header 的 Code 字段在服务端与客户端之间传递，0 表示未分类的错误
+type ErrorCode int
+
+const (
+	CodeOverloaded  ErrorCode = iota + 1 // 服务端过载，请求未被处理
+	CodeRateLimited                      // 请求被限流，请求未被处理
+	CodeTimeout                          // 服务端处理超时
+)
+
+// 响应头元数据中记录重试等待时长的键
+const retryAfterKey = "retry-after"
+
+// Error 表示带错误码的 RPC 错误，客户端可以通过 errors.Is 或 errors.As 判断错误类型
+type Error struct {
+	Code       ErrorCode
+	Message    string
+	RetryAfter time.Duration // 建议的重试等待时长，0 表示没有建议
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// 错误码相同即视为同一类错误
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var ErrServerOverloaded = &Error{Code: CodeOverloaded, Message: "rpc server: server overloaded"}
+
+var ErrRateLimited = &Error{Code: CodeRateLimited, Message: "rpc server: rate limited"}
+
+var ErrHandleTimeout = &Error{Code: CodeTimeout, Message: "rpc server: request handle timeout"}
+
+// ServerError 表示服务端返回的未分类错误，如方法返回的业务错误，与连接、超时等传输错误相区分
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// 返回错误中服务端建议的重试等待时长
+func RetryAfter(err error) (time.Duration, bool) {
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		return e.RetryAfter, true
+	}
+	return 0, false
+}
+
+// 将错误写入响应头，带错误码的错误同时写入错误码
+func setHeaderError(h *codec.Header, err error) {
+	h.Error = err.Error()
+	var e *Error
+	if errors.As(err, &e) {
+		h.Code = int(e.Code)
+		if e.RetryAfter > 0 {
+			h.Metadata = map[string]string{retryAfterKey: e.RetryAfter.String()}
+		}
+	}
+}
+
+// 从响应头还原服务端返回的错误
+func headerError(h *codec.Header) error {
+	if h.Code != 0 {
+		e := &Error{Code: ErrorCode(h.Code), Message: h.Error}
+		e.RetryAfter, _ = time.ParseDuration(h.Metadata[retryAfterKey])
+		return e
+	}
+	return ServerError(h.Error)
+}
@@ -1,3 +1,3 @@
 module zrpc
 
-go 1.16
+go 1.18
@@ -0,0 +1,44 @@
+package zrpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"net"
+	"testing"
+	"zrpc/codec"
+)
+
+// Option 与之后的请求在同一次写入中到达时，json.Decoder 会预读请求数据，
+// 服务端需要将预读的数据交给 codec，且不能把 Option 末尾的换行符当作请求
+func TestServer_HandshakeReadAhead(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+	go server.ServeConn(serverConn)
+
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(DefaultOption)
+	enc := gob.NewEncoder(&buf)
+	for seq := uint64(1); seq <= 2; seq++ {
+		_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: seq})
+		_ = enc.Encode(&Args{Num1: int(seq), Num2: 1})
+	}
+	go func() { _, _ = clientConn.Write(buf.Bytes()) }()
+
+	// 请求并发处理，响应的顺序不确定
+	dec := gob.NewDecoder(clientConn)
+	replies := make(map[uint64]int)
+	for i := 0; i < 2; i++ {
+		var h codec.Header
+		var reply int
+		err := dec.Decode(&h)
+		_assert(err == nil && h.Error == "", "read header error: %v %s", err, h.Error)
+		err = dec.Decode(&reply)
+		_assert(err == nil, "read body error: %v", err)
+		replies[h.Seq] = reply
+	}
+	_assert(replies[1] == 2 && replies[2] == 3, "expect replies 2 and 3, but got %v", replies)
+}
@@ -0,0 +1,158 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 内置的服务发现服务，服务名以 "_" 开头，不会出现在 ServiceNames 中
+const (
+	introspectionService = "_zrpc"
+	listServicesMethod   = introspectionService + ".ListServices"
+	describeMethodMethod = introspectionService + ".DescribeMethod"
+)
+
+// 服务的描述
+type ServiceInfo struct {
+	Name    string   // 服务名
+	Methods []string // 方法名，按字典序排列
+}
+
+// 方法的描述
+type MethodInfo struct {
+	Service  string
+	Method   string
+	Context  bool     // 方法的第一个参数为 context.Context
+	Args     TypeInfo // 参数类型
+	Reply    TypeInfo // 返回值类型，不含最外层的指针
+	NumCalls uint64   // 累计调用次数
+}
+
+// 类型的结构描述，足以让不依赖编译期类型的工具构造参数
+type TypeInfo struct {
+	Name   string      // 类型名，如 "zrpc.Args"、"int"，匿名类型为空
+	Kind   string      // reflect.Kind 的名称，如 "struct"、"ptr"、"slice"
+	Elem   *TypeInfo   `json:",omitempty"` // 指针、数组、切片与 map 的元素类型
+	Key    *TypeInfo   `json:",omitempty"` // map 的键类型
+	Fields []FieldInfo `json:",omitempty"` // 结构体的导出字段
+}
+
+// 结构体字段的描述
+type FieldInfo struct {
+	Name string
+	Tag  string `json:",omitempty"`
+	Type TypeInfo
+}
+
+// 新建内置的服务发现服务
+func newIntrospectionService(server *Server) *service {
+	s := newFuncService(introspectionService)
+	funcs := map[string]interface{}{
+		"ListServices":   server.listServices,
+		"DescribeMethod": server.describeMethod,
+	}
+	for name, fn := range funcs {
+		m, err := newFuncType(fn)
+		if err != nil {
+			panic(err)
+		}
+		m.name = name
+		s.method[name] = m
+	}
+	return s
+}
+
+// 返回服务名为 name 的服务描述，name 为空时返回所有服务
+func (server *Server) listServices(name string, reply *[]ServiceInfo) error {
+	infos := make([]ServiceInfo, 0)
+	for _, svcName := range server.ServiceNames() {
+		if name != "" && svcName != name {
+			continue
+		}
+		svci, ok := server.serviceMap.Load(svcName)
+		if !ok {
+			continue
+		}
+		info := ServiceInfo{Name: svcName}
+		for methodName := range svci.(*service).methods() {
+			info.Methods = append(info.Methods, methodName)
+		}
+		sort.Strings(info.Methods)
+		infos = append(infos, info)
+	}
+	if name != "" && len(infos) == 0 {
+		return errors.New("rpc server: can't find service " + name)
+	}
+	*reply = infos
+	return nil
+}
+
+// 返回形如 "Service.Method" 的方法描述
+func (server *Server) describeMethod(serviceMethod string, reply *MethodInfo) error {
+	if strings.HasPrefix(serviceMethod, "_") {
+		return errors.New("rpc server: can't describe built-in method " + serviceMethod)
+	}
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	*reply = MethodInfo{
+		Service:  svc.name,
+		Method:   mtype.name,
+		Context:  mtype.hasCtx,
+		Args:     describeType(mtype.ArgType, nil),
+		Reply:    describeType(mtype.ReplyType.Elem(), nil),
+		NumCalls: mtype.NumCalls(),
+	}
+	return nil
+}
+
+// 返回类型 t 的结构描述，seen 记录正在展开的结构体，避免递归类型无限展开
+func describeType(t reflect.Type, seen map[reflect.Type]bool) TypeInfo {
+	info := TypeInfo{Name: t.String(), Kind: t.Kind().String()}
+	if t.Name() == "" {
+		info.Name = ""
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		elem := describeType(t.Elem(), seen)
+		info.Elem = &elem
+	case reflect.Map:
+		key, elem := describeType(t.Key(), seen), describeType(t.Elem(), seen)
+		info.Key, info.Elem = &key, &elem
+	case reflect.Struct:
+		if seen[t] {
+			return info
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			info.Fields = append(info.Fields, FieldInfo{Name: f.Name, Tag: string(f.Tag), Type: describeType(f.Type, seen)})
+		}
+	}
+	return info
+}
+
+// 调用内置的服务发现服务，返回服务端发布的所有服务
+func ListServices(ctx context.Context, c Caller) ([]ServiceInfo, error) {
+	return Invoke[string, []ServiceInfo](ctx, c, listServicesMethod, "")
+}
+
+// 调用内置的服务发现服务，返回形如 "Service.Method" 的方法描述
+func DescribeMethod(ctx context.Context, c Caller, serviceMethod string) (*MethodInfo, error) {
+	info, err := Invoke[string, MethodInfo](ctx, c, describeMethodMethod, serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
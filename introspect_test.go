@@ -0,0 +1,53 @@
+package zrpc
+
+import (
+	"context"
+	"testing"
+)
+
+// 包含递归引用的参数类型
+type Node struct {
+	Value    int
+	Children []*Node
+	Labels   map[string]string
+	parent   *Node
+}
+
+type Tree int
+
+func (t Tree) Size(ctx context.Context, root *Node, reply *int) error {
+	*reply = 1
+	return nil
+}
+
+func TestIntrospection(t *testing.T) {
+	addr := startServer(t, nil)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	services, err := ListServices(context.Background(), client)
+	_assert(err == nil && len(services) == 2, "expect Bar and Foo, but got %v, %v", services, err)
+	_assert(services[1].Name == "Foo" && services[1].Methods[0] == "Sum", "expect Foo.Sum, but got %v", services[1])
+
+	info, err := DescribeMethod(context.Background(), client, "Foo.Sum")
+	_assert(err == nil, "describe error: %v", err)
+	_assert(info.Args.Name == "zrpc.Args" && len(info.Args.Fields) == 2 && info.Reply.Kind == "int",
+		"unexpected method info %+v", info)
+	_, err = DescribeMethod(context.Background(), client, "Foo.Missing")
+	_assert(err != nil, "expect error for missing method")
+}
+
+func TestDescribeType_Recursive(t *testing.T) {
+	var tree Tree
+	s, _ := newService(&tree)
+	m := s.method["Size"]
+	info := describeType(m.ArgType, nil)
+	_assert(info.Kind == "ptr" && info.Elem.Name == "zrpc.Node", "unexpected type info %+v", info)
+	node := info.Elem
+	_assert(len(node.Fields) == 3, "expect unexported field skipped, but got %d fields", len(node.Fields))
+	child := node.Fields[1].Type.Elem.Elem
+	_assert(child.Name == "zrpc.Node" && child.Fields == nil, "expect recursive type not expanded, but got %+v", child)
+	_assert(node.Fields[2].Type.Key.Kind == "string", "expect map key described")
+	_assert(m.hasCtx, "expect ctx method")
+}
@@ -0,0 +1,129 @@
+package zrpc
+
+import (
+	"sync/atomic"
+)
+
+// ConcurrencyLimit 表示并发限制的配置
+type ConcurrencyLimit struct {
+	MaxConcurrent int // 同时处理的最大请求数
+	MaxQueued     int // 超出并发限制时最多排队等待的请求数，队列已满时返回 ErrServerOverloaded
+}
+
+// 并发限制器：最多 MaxConcurrent 个请求同时处理，超出的请求最多 MaxQueued 个排队等待
+type limiter struct {
+	name   string
+	limit  ConcurrencyLimit
+	sem    chan struct{} // 执行名额
+	queued int64         // 排队等待的请求数，原子访问
+}
+
+func newLimiter(name string, limit ConcurrencyLimit) *limiter {
+	return &limiter{name: name, limit: limit, sem: make(chan struct{}, limit.MaxConcurrent)}
+}
+
+// 申请执行名额：能立即执行时返回 false；否则占用一个排队名额并返回 true，队列已满时返回错误
+func (l *limiter) admit() (queued bool, err error) {
+	select {
+	case l.sem <- struct{}{}:
+		return false, nil
+	default:
+	}
+	if atomic.AddInt64(&l.queued, 1) > int64(l.limit.MaxQueued) {
+		atomic.AddInt64(&l.queued, -1)
+		return false, ErrServerOverloaded
+	}
+	return true, nil
+}
+
+// 只占用一个排队名额，执行名额在 wait 中申请，用于已在方法级限制器上排队的请求。
+// 正在处理与排队的请求总数不超过 MaxConcurrent + MaxQueued，超出时返回错误
+func (l *limiter) reserve() error {
+	n := atomic.AddInt64(&l.queued, 1)
+	if int(n)+len(l.sem) > l.limit.MaxConcurrent+l.limit.MaxQueued {
+		atomic.AddInt64(&l.queued, -1)
+		return ErrServerOverloaded
+	}
+	return nil
+}
+
+// 排队等待执行名额
+func (l *limiter) wait() {
+	l.sem <- struct{}{}
+	atomic.AddInt64(&l.queued, -1)
+}
+
+// 放弃排队
+func (l *limiter) dequeue() {
+	atomic.AddInt64(&l.queued, -1)
+}
+
+// 归还执行名额
+func (l *limiter) release() {
+	<-l.sem
+}
+
+// 正在处理的请求数
+func (l *limiter) Running() int {
+	return len(l.sem)
+}
+
+// 排队等待的请求数
+func (l *limiter) Queued() int {
+	return int(atomic.LoadInt64(&l.queued))
+}
+
+// 一个请求在各级限制器上申请到的名额
+type ticket struct {
+	limiters []*limiter
+	queued   []bool
+}
+
+// 依次在方法级与服务端级限制器上申请名额，任一级别队列已满时归还已申请的名额并返回错误。
+// 在方法级排队的请求只在服务端级预留排队名额，获得方法级名额后才在 wait 中占用服务端级的执行名额，
+// 避免排队的请求占满服务端级名额而拒绝其他方法的请求
+func (server *Server) admit(serviceMethod string) (*ticket, error) {
+	t := &ticket{}
+	waiting := false
+	for _, l := range []*limiter{server.methodLimiters[serviceMethod], server.limiter} {
+		if l == nil {
+			continue
+		}
+		var queued bool
+		var err error
+		if waiting {
+			queued, err = true, l.reserve()
+		} else {
+			queued, err = l.admit()
+		}
+		if err != nil {
+			t.release()
+			return nil, err
+		}
+		t.limiters = append(t.limiters, l)
+		t.queued = append(t.queued, queued)
+		waiting = waiting || queued
+	}
+	return t, nil
+}
+
+// 按方法级、服务端级的顺序等待所有排队的名额
+func (t *ticket) wait() {
+	for i, l := range t.limiters {
+		if t.queued[i] {
+			l.wait()
+			t.queued[i] = false
+		}
+	}
+}
+
+// 归还所有已获得的名额，并放弃仍在排队的名额
+func (t *ticket) release() {
+	for i, l := range t.limiters {
+		if t.queued[i] {
+			l.dequeue()
+		} else {
+			l.release()
+		}
+	}
+}
@@ -39,7 +39,7 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	lis, _ := net.Listen("tcp", ":0")
 	server := zrpc.NewServer()
 	_ = server.Register(&foo)
-	registry.Heartbeat(registryAddr, "tcp@"+lis.Addr().String(), 0)
+	registry.HeartbeatServices(registryAddr, "tcp@"+lis.Addr().String(), 0, server.ServiceNames)
 	wg.Done()
 	server.Accept(lis)
 }
@@ -87,8 +87,9 @@ func broadcast(registry string) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
 			// expect 2 - 5 timeout
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,23 @@
+package zrpc
+
+import "context"
+
+type metadataKey struct{}
+
+// 返回携带元数据的 context，Client.Call 会将元数据随请求发送给服务端，已有的同名键将被覆盖
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// 返回 context 携带的元数据，不存在时返回 nil
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
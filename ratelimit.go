@@ -0,0 +1,135 @@
+package zrpc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit 表示令牌桶限流的配置
+type RateLimit struct {
+	Rate  float64 // 每秒生成的令牌数，值为 0 时表示不限制
+	Burst int     // 令牌桶容量，即允许的突发请求数，值小于 1 时按 1 处理
+}
+
+// 令牌桶
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64   // 当前令牌数
+	last   time.Time // 上一次更新令牌数的时间
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// 取出一个令牌，令牌不足时返回 false 以及获得下一个令牌需要等待的时长
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 并发的请求可能以早于 last 的时间调用，此时不补充令牌
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// 归还一个已取出的令牌
+func (b *tokenBucket) put() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+1)
+}
+
+// 令牌桶是否已满，满的令牌桶与新建的令牌桶等价，可以被回收
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// 调用方令牌桶数量超过该值时，回收已满的令牌桶
+const maxIdleCallerBuckets = 1024
+
+// 服务端限流器，依次检查调用方、方法与全局的令牌桶
+type rateLimiter struct {
+	global      *tokenBucket
+	methods     map[string]*tokenBucket // 创建后只读
+	callerLimit RateLimit
+	mu          sync.Mutex
+	callers     map[string]*tokenBucket
+}
+
+func newRateLimiter(opt *ServerOption) *rateLimiter {
+	rl := &rateLimiter{
+		methods:     make(map[string]*tokenBucket),
+		callerLimit: opt.CallerRateLimit,
+		callers:     make(map[string]*tokenBucket),
+	}
+	if opt.RateLimit.Rate > 0 {
+		rl.global = newTokenBucket(opt.RateLimit)
+	}
+	for serviceMethod, limit := range opt.MethodRateLimits {
+		if limit.Rate > 0 {
+			rl.methods[serviceMethod] = newTokenBucket(limit)
+		}
+	}
+	return rl
+}
+
+// 返回调用方的令牌桶，未配置调用方限流时返回 nil
+func (rl *rateLimiter) caller(caller string, now time.Time) *tokenBucket {
+	if rl.callerLimit.Rate <= 0 {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b := rl.callers[caller]
+	if b == nil {
+		if len(rl.callers) >= maxIdleCallerBuckets {
+			for k, cb := range rl.callers {
+				if cb.full(now) {
+					delete(rl.callers, k)
+				}
+			}
+		}
+		b = newTokenBucket(rl.callerLimit)
+		rl.callers[caller] = b
+	}
+	return b
+}
+
+// 检查请求是否被限流，被限流时返回带有重试等待时长的 ErrRateLimited
+func (rl *rateLimiter) allow(caller, serviceMethod string) error {
+	now := time.Now()
+	buckets := []*tokenBucket{rl.caller(caller, now), rl.methods[serviceMethod], rl.global}
+	for i, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if ok, wait := b.take(now); !ok {
+			// 请求被拒绝，归还之前的令牌桶中已取出的令牌
+			for _, taken := range buckets[:i] {
+				if taken != nil {
+					taken.put()
+				}
+			}
+			return &Error{
+				Code:       CodeRateLimited,
+				Message:    fmt.Sprintf("rpc server: rate limited, retry after %s", wait),
+				RetryAfter: wait,
+			}
+		}
+	}
+	return nil
+}
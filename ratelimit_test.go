@@ -0,0 +1,23 @@
+package zrpc
+
+import (
+	"errors"
+	"testing"
+)
+
+// 被方法或全局限流拒绝的请求不消耗调用方的令牌
+func TestRateLimiter_Refund(t *testing.T) {
+	rl := newRateLimiter(&ServerOption{
+		CallerRateLimit:  RateLimit{Rate: 0.001, Burst: 2},
+		MethodRateLimits: map[string]RateLimit{"Foo.Sum": {Rate: 0.001, Burst: 1}},
+	})
+	_assert(rl.allow("a", "Foo.Sum") == nil, "expect first call allowed")
+	for i := 0; i < 5; i++ {
+		err := rl.allow("a", "Foo.Sum")
+		_assert(errors.Is(err, ErrRateLimited), "expect method rate limited, but got %v", err)
+	}
+	// 调用方仍剩余一个令牌
+	_assert(rl.allow("a", "Foo.Max") == nil, "expect caller token refunded")
+	err := rl.allow("a", "Foo.Max")
+	_assert(errors.Is(err, ErrRateLimited), "expect caller rate limited, but got %v", err)
+}
@@ -16,8 +16,9 @@ type ZRegistry struct {
 }
 
 type ServerItem struct {
-	Addr  string     // 服务地址
-	start time.Time  // 上一次发送心跳时间
+	Addr     string     // 服务地址
+	Services []string   // 实例上发布的服务名称，为空表示未上报
+	start    time.Time  // 上一次发送心跳时间
 }
 
 const (
@@ -35,28 +36,34 @@ func New(timeout time.Duration) *ZRegistry {
 
 var DefaultGeeRegister = New(defaultTimeout)
 
-// 添加服务实例，如果服务已经存在，则更新 start
-func (r *ZRegistry) putServer(addr string) {
+// 添加服务实例，如果服务已经存在，则更新 start；services 不为 nil 时更新实例上发布的服务
+func (r *ZRegistry) putServer(addr string, services []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	s := r.servers[addr]
 	if s == nil {
 		// 若实例不存在，则新建一个服务实例
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+		r.servers[addr] = &ServerItem{Addr: addr, Services: services, start: time.Now()}
 	} else {
 		// 若实例存在，则更新心跳时间
 		s.start = time.Now() 
+		if services != nil {
+			s.Services = services
+		}
 	}
 }
 
-// 返回可用的服务列表，如果存在超时的服务，则删除
-func (r *ZRegistry) aliveServers() []string {
+// 返回可用的服务列表，如果存在超时的服务，则删除。
+// service 不为空时只返回发布了该服务或未上报服务的实例
+func (r *ZRegistry) aliveServers(service string) []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var alive []string
 	for addr, s := range r.servers {
 		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
+			if service == "" || s.provides(service) {
+				alive = append(alive, addr)
+			}
 		} else {
 			delete(r.servers, addr)
 		}
@@ -65,13 +72,27 @@ func (r *ZRegistry) aliveServers() []string {
 	return alive
 }
 
+// 判断实例是否发布了服务 service，未上报服务的实例（如使用 Heartbeat 的实例）视为可能发布了任意服务
+func (s *ServerItem) provides(service string) bool {
+	if s.Services == nil {
+		return true
+	}
+	for _, name := range s.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
 // ZRegistry 采用 HTTP 协议提供服务，且所有的有用信息都承载在 HTTP Header 中
 // 运行在 /_zrpc_/registry 
 func (r *ZRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		// 返回所有可用的服务列表，通过自定义字段 X-Zrpc-Servers 承载
-		w.Header().Set("X-Zrpc-Servers", strings.Join(r.aliveServers(), ","))
+		// 返回所有可用的服务列表，通过自定义字段 X-Zrpc-Servers 承载，
+		// 可通过查询参数 service 只返回发布了该服务或未上报服务的实例
+		w.Header().Set("X-Zrpc-Servers", strings.Join(r.aliveServers(req.URL.Query().Get("service")), ","))
 	case "POST":
 		// 添加服务实例或发送心跳，通过自定义字段 X-Zrpc-Server 承载
 		addr := req.Header.Get("X-Zrpc-Server")
@@ -79,7 +100,15 @@ func (r *ZRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		// 实例上发布的服务通过自定义字段 X-Zrpc-Services 承载
+		var services []string
+		if v, ok := req.Header["X-Zrpc-Services"]; ok {
+			services = []string{}
+			if len(v) > 0 && v[0] != "" {
+				services = strings.Split(v[0], ",")
+			}
+		}
+		r.putServer(addr, services)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -97,27 +126,36 @@ func HandleHTTP() {
 
 // 作为服务器的辅助函数，每过一段时间发送一条心跳消息
 func Heartbeat(registry, addr string, duration time.Duration) {
+	HeartbeatServices(registry, addr, duration, nil)
+}
+
+// 与 Heartbeat 相同，并在每次心跳时上报 services 返回的服务名称，
+// 使注册中心感知服务的发布与注销，通常传入 Server.ServiceNames
+func HeartbeatServices(registry, addr string, duration time.Duration, services func() []string) {
 	if duration == 0 {
 		// 保证在被注册中心移除之前，有足够的时间发送心跳
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
 	var err error
-	err = sendHeartbeat(registry, addr)
+	err = sendHeartbeat(registry, addr, services)
 	go func() {
 		t := time.NewTicker(duration)
 		for err == nil {
 			<-t.C
-			err = sendHeartbeat(registry, addr)
+			err = sendHeartbeat(registry, addr, services)
 		}
 	}()
 }
 
 // 发送心跳
-func sendHeartbeat(registry, addr string) error {
+func sendHeartbeat(registry, addr string, services func() []string) error {
 	log.Println(addr, "send heart beat to registry", registry)
 	httpClient := &http.Client{}
 	req, _ := http.NewRequest("POST", registry, nil)
 	req.Header.Set("X-Zrpc-Server", addr)
+	if services != nil {
+		req.Header.Set("X-Zrpc-Services", strings.Join(services(), ","))
+	}
 	if _, err := httpClient.Do(req); err != nil {
 		log.Println("rpc server: heart beat err:", err)
 		return err
@@ -1,12 +1,17 @@
 package zrpc
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"go/ast"
 	"io"
 	"log"
 	"net"
 	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"strings"
 	"errors"
 	"time"
@@ -23,22 +28,91 @@ type Option struct {
 	CodecType   codec.Type       // 客户端可以选择不同 Codec 来解码 body
 	ConnectTimeout time.Duration // 客户端创建连接限时，默认值为 10s
 	HandleTimeout  time.Duration // 值为 0 时表示没有时间限制
+	KeepaliveInterval time.Duration // 连接空闲（未收到任何数据）超过该时长时，客户端发送心跳，值为 0 时表示不发送
+	KeepaliveTimeout  time.Duration // 发送心跳后超过该时长仍未收到回复，则认为连接已断开
 }
 
+// 心跳请求的 ServiceMethod，由服务端直接回复，不经过 serviceMap
+const pingServiceMethod = "_zrpc.Ping"
+
+// 服务端通知客户端不再在该连接上发起新请求时使用的 ServiceMethod，Seq 为 0，
+// Error 不为空时表示连接被拒绝
+const goAwayServiceMethod = "_zrpc.GoAway"
+
 var DefaultOption = &Option{
 	MagicNumber: MagicNumber,
 	CodecType:   codec.GobType,
 	ConnectTimeout: time.Second * 10,
 }
 
+// ServerOption 表示服务端的配置
+type ServerOption struct {
+	IdleTimeout           time.Duration // 连接上超过该时长没有请求时关闭连接，值为 0 时表示不限制
+	MaxConnectionAge      time.Duration // 连接存活超过该时长后发送 GOAWAY，通知客户端重新建立连接，值为 0 时表示不限制
+	MaxConnectionAgeGrace time.Duration // 发送 GOAWAY 后等待未完成请求的时长，超时后强制关闭连接，值为 0 时表示不强制关闭
+	MaxConnections        int           // 最大并发连接数，超出时拒绝新连接，值为 0 时表示不限制
+	Concurrency           ConcurrencyLimit            // 服务端级别的并发限制，MaxConcurrent 为 0 时表示不限制
+	MethodConcurrency     map[string]ConcurrencyLimit // 以 "Service.Method" 为键的方法级并发限制
+	RateLimit             RateLimit            // 全局限流，Rate 为 0 时表示不限制
+	MethodRateLimits      map[string]RateLimit // 以 "Service.Method" 为键的方法级限流，所有调用方共享
+	CallerRateLimit       RateLimit            // 每个调用方的限流，所有方法共享
+	CallerKey             string               // 从请求元数据中读取调用方标识的键，为空或元数据中不存在时以远端地址作为调用方标识
+	AdaptiveLimit         *AdaptiveLimit       // 根据处理延迟自适应调整的并发限制，为 nil 时不启用
+	MethodTimeouts        map[string]time.Duration // 以 "Service.Method" 或 "Service" 为键的处理限时，前者优先，作为客户端 HandleTimeout 的上限
+	StrictRegistration    bool                     // 为 true 时，服务的任一导出方法不符合 RPC 方法的形式都拒绝注册
+}
+
+var DefaultServerOption = &ServerOption{}
+
 // Server 表示一个 RPC 服务器
 type Server struct{
 	serviceMap sync.Map
+	opt        *ServerOption
+	conns      int64 // 当前连接数，原子访问
+	limiter        *limiter            // 服务端级别的并发限制器，为 nil 时不限制
+	methodLimiters map[string]*limiter // 方法级并发限制器，创建后只读
+	rateLimiter    *rateLimiter
+	adaptive       *adaptiveLimiter // 自适应并发限制器，为 nil 时不启用
+}
+
+// 对传入的 ServerOption 做语法分析
+func parseServerOptions(opts ...*ServerOption) (*ServerOption, error) {
+	if len(opts) == 0 || opts[0] == nil {
+		return DefaultServerOption, nil
+	}
+	if len(opts) != 1 {
+		return nil, errors.New("number of server options is more than 1")
+	}
+	return opts[0], nil
+}
+
+// 服务器新建函数，opts 最多包含一个 ServerOption
+func NewServer(opts ...*ServerOption) *Server {
+	opt, err := parseServerOptions(opts...)
+	if err != nil {
+		log.Println("rpc server: options error:", err)
+		opt = DefaultServerOption
+	}
+	server := &Server{opt: opt, methodLimiters: make(map[string]*limiter), rateLimiter: newRateLimiter(opt)}
+	if opt.Concurrency.MaxConcurrent > 0 {
+		server.limiter = newLimiter("server", opt.Concurrency)
+	}
+	if opt.AdaptiveLimit != nil {
+		server.adaptive = newAdaptiveLimiter(*opt.AdaptiveLimit)
+	}
+	for serviceMethod, limit := range opt.MethodConcurrency {
+		if limit.MaxConcurrent > 0 {
+			server.methodLimiters[serviceMethod] = newLimiter(serviceMethod, limit)
+		}
+	}
+	// 内置的服务发现服务，可通过 Unregister("_zrpc") 关闭
+	server.serviceMap.Store(introspectionService, newIntrospectionService(server))
+	return server
 }
 
-// 服务器新建函数
-func NewServer() *Server {
-	return &Server{}
+// 返回当前的连接数
+func (server *Server) NumConns() int {
+	return int(atomic.LoadInt64(&server.conns))
 }
 
 // *Server 的默认实例
@@ -51,7 +125,8 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	}()
 	var opt Option
 	// 将 conn 接收的下一个 JSON 编码的值反序列化后写入 opt 
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error", err)
 		return
 	}
@@ -66,16 +141,68 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
 		return
 	}
-	server.serveCodec(f(conn), &opt)
+	// json.Decoder 可能预读了 Option 之后的请求数据，需要将其与 conn 一起交给 codec，
+	// 并跳过 json.Encoder 在 Option 末尾写入的换行符
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	cc := f(&bufferedConn{Reader: r, ReadWriteCloser: conn})
+	n := atomic.AddInt64(&server.conns, 1)
+	defer atomic.AddInt64(&server.conns, -1)
+	// 超过最大连接数时，通过 GOAWAY 告知客户端拒绝的原因
+	if max := server.opt.MaxConnections; max > 0 && n > int64(max) {
+		log.Printf("rpc server: too many connections, max %d", max)
+		h := &codec.Header{ServiceMethod: goAwayServiceMethod, Error: "rpc server: too many connections"}
+		server.sendResponse(cc, h, invalidRequest, new(sync.Mutex))
+		return
+	}
+	server.serveCodec(cc, &opt, remoteHost(conn))
+}
+
+// 返回连接的远端主机地址，无法获取时返回空字符串
+func remoteHost(conn io.ReadWriteCloser) string {
+	c, ok := conn.(interface{ RemoteAddr() net.Addr })
+	if !ok {
+		return ""
+	}
+	addr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// 返回请求的调用方标识：优先使用元数据中 CallerKey 对应的值，否则使用远端地址
+func (server *Server) callerOf(req *request, remote string) string {
+	if key := server.opt.CallerKey; key != "" {
+		if caller, ok := req.md[key]; ok {
+			return caller
+		}
+	}
+	return remote
+}
+
+// 优先从 Reader 读取数据的连接，其余操作交给原连接
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
 }
 
 // 发生错位时响应参数的一个占位符
 var invalidRequest = struct{}{}
 
-// 读取、处理并回复请求
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
+// 读取、处理并回复请求，remote 为连接的远端地址
+func (server *Server) serveCodec(cc codec.Codec, opt *Option, remote string) {
 	sending := new(sync.Mutex)  // 确保发送的是完整响应
 	wg := new(sync.WaitGroup)   // 等待所有请求处理完毕
+	cs := newConnState()
+	go server.watchConn(cc, cs, sending)
+	defer close(cs.done)
 	for {
 		// 读取请求
 		req, err := server.readRequest(cc)
@@ -84,14 +211,56 @@ func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
 				// 只有在 header 解析失败时，才终止循环
 				break
 			}
-			req.h.Error = err.Error()
+			setHeaderError(req.h, err)
 			// 回复请求（通过锁 sending 保证串行）
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
+		// 直接回复心跳请求
+		if req.h.ServiceMethod == pingServiceMethod {
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
+		// 检查限流，被限流时直接回复带有重试等待时长的错误
+		if err := server.rateLimiter.allow(server.callerOf(req, remote), req.h.ServiceMethod); err != nil {
+			setHeaderError(req.h, err)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
+		// 自适应并发限制在过载时尽早拒绝请求
+		if server.adaptive != nil {
+			if err := server.adaptive.acquire(); err != nil {
+				setHeaderError(req.h, err)
+				server.sendResponse(cc, req.h, invalidRequest, sending)
+				continue
+			}
+		}
+		// 申请执行名额，队列已满时直接回复过载错误
+		t, err := server.admit(req.h.ServiceMethod)
+		if err != nil {
+			if server.adaptive != nil {
+				server.adaptive.cancel()
+			}
+			setHeaderError(req.h, err)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
 		wg.Add(1)
-		// 处理请求（通过协程并发执行）
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		cs.begin()
+		// 处理请求（通过协程并发执行），排队的请求在获得名额后才开始处理
+		go func() {
+			t.wait()
+			start := time.Now()
+			// 名额在方法真正返回后才归还，处理超时不会使并发数超过限制
+			done := func() {
+				if server.adaptive != nil {
+					server.adaptive.release(time.Since(start))
+				}
+				t.release()
+				cs.end()
+			}
+			server.handleRequest(cc, req, sending, wg, server.handleTimeout(req.h.ServiceMethod, opt.HandleTimeout), done)
+		}()
 	}
 	wg.Wait()
 	_ = cc.Close()
@@ -101,8 +270,10 @@ func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
 type request struct {
 	h             *codec.Header //请求头
 	argv, replyv  reflect.Value //请求参数与返回值
+	args, reply   interface{}   //请求参数与返回值的指针，argv 无效时通过 Handler 调用
 	mtype         *methodType   //请求方法类型
 	svc           *service	    //请求服务
+	md            map[string]string //请求携带的元数据
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
@@ -132,7 +303,7 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	}
 	// 从 service 实例的 method 中，找到对应的 methodType
 	svc = svci.(*service)
-	mtype = svc.method[methodName]
+	mtype = svc.methodOf(methodName)
 	if mtype == nil {
 		err = errors.New("rpc server: can't find method " + methodName)
 	}
@@ -144,21 +315,36 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
+	// 元数据只属于请求，不随响应返回
+	req := &request{h: h, md: h.Metadata}
+	h.Metadata = nil
+	// 心跳请求不对应任何服务，丢弃请求体即可
+	if h.ServiceMethod == pingServiceMethod {
+		return req, cc.ReadBody(nil)
+	}
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// 丢弃请求体，保证下一个请求能被正确读取
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
-	// 创建两个入参实例
-	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
-	// 确保 argvi 是一个指针，因为 ReadBody 需要指针作为参数
-	argvi := req.argv.Interface()
-	if req.argv.Type().Kind() != reflect.Ptr {
-		argvi = req.argv.Addr().Interface()
+	// 服务实现了 Handler 时，不经过反射创建两个入参实例
+	if req.svc.handler != nil {
+		req.args, req.reply = req.svc.handler.NewArgs(req.mtype.name)
+	}
+	if req.args == nil || req.reply == nil {
+		// 创建两个入参实例
+		req.argv = req.mtype.newArgv()
+		req.replyv = req.mtype.newReplyv()
+		// 确保 argvi 是一个指针，因为 ReadBody 需要指针作为参数
+		req.args = req.argv.Interface()
+		if req.argv.Type().Kind() != reflect.Ptr {
+			req.args = req.argv.Addr().Interface()
+		}
+		req.reply = req.replyv.Interface()
 	}
 	// 将请求报文反序列化为第一个入参 argv
-	if err = cc.ReadBody(argvi); err != nil {
+	if err = cc.ReadBody(req.args); err != nil {
 		log.Println("rpc server: read body err:", err)
 		return req, err
 	}
@@ -173,21 +359,63 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 	}
 }
 
+// 返回请求的处理限时：取客户端要求的限时与服务端为方法或服务配置的限时中较小者，0 表示没有时间限制
+func (server *Server) handleTimeout(serviceMethod string, timeout time.Duration) time.Duration {
+	limit, ok := server.opt.MethodTimeouts[serviceMethod]
+	if !ok {
+		if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+			limit, ok = server.opt.MethodTimeouts[serviceMethod[:dot]]
+		}
+	}
+	if ok && limit > 0 && (timeout == 0 || limit < timeout) {
+		return limit
+	}
+	return timeout
+}
+
+// 请求上下文中记录处理该请求的服务，供 UnregisterContext 识别服务在自身的方法中注销
+type serviceKey struct{}
+
 // 请求已注册的 rpc 方法，来获取正确返回值
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+// done 在方法返回后调用，处理超时时也要等到方法返回
+func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration, done func()) {
 	defer wg.Done()
+	// 服务已被注销时不再处理新的请求
+	if !req.svc.begin() {
+		done()
+		req.h.Error = "rpc server: service " + req.svc.name + " is unregistered"
+		server.sendResponse(cc, req.h, invalidRequest, sending)
+		return
+	}
+	// 以 context.Context 为第一个参数的方法可获取请求元数据，并在处理超时后感知取消
+	ctx := context.WithValue(context.Background(), serviceKey{}, req.svc)
+	if req.md != nil {
+		ctx = WithMetadata(ctx, req.md)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	called := make(chan struct{})
 	sent := make(chan struct{})
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
+		var err error
+		if req.argv.IsValid() {
+			err = req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+		} else {
+			err = req.svc.handle(ctx, req.mtype, req.args, req.reply)
+		}
+		req.svc.end()
+		done()
 		called <- struct{}{}
 		if err != nil {
-			req.h.Error = err.Error()
+			setHeaderError(req.h, err)
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			sent <- struct{}{}
 			return
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		server.sendResponse(cc, req.h, req.reply, sending)
 		sent <- struct{}{}
 	}()
 	if timeout == 0 {
@@ -198,7 +426,7 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	select {
 	// time.After() 先于 called 接收到消息，说明处理已经超时，called 和 sent 都将被阻塞
 	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+		setHeaderError(req.h, &Error{Code: CodeTimeout, Message: fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)})
 		server.sendResponse(cc, req.h, invalidRequest, sending)
 	// called 信道接收到消息，代表处理没有超时，继续执行 sendResponse
 	case <-called:
@@ -226,16 +454,98 @@ func Accept(lis net.Listener) {
 
 // 在服务端中发布新方法
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+// 以 name 作为服务名发布接收方的方法，便于发布 FooV2 这类带版本的服务，name 为空时使用结构体的名称
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	s, err := newNamedService(rcvr, name, server.opt.StrictRegistration)
+	if err != nil {
+		return err
+	}
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
 	return nil
 }
 
+// 将函数 fn 发布为 serviceMethod（形如 "Service.Method"）方法，fn 的形式为
+// func(args T1, reply *T2) error 或 func(ctx context.Context, args T1, reply *T2) error。
+// 同一服务名下可发布多个函数，但不能与 Register 发布的服务同名
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if !ast.IsExported(serviceName) || !ast.IsExported(methodName) {
+		return fmt.Errorf("rpc server: %q is not a valid service method name", serviceMethod)
+	}
+	m, err := newFuncType(fn)
+	if err != nil {
+		return fmt.Errorf("rpc server: %s: %v", serviceMethod, err)
+	}
+	svci, _ := server.serviceMap.LoadOrStore(serviceName, newFuncService(serviceName))
+	return svci.(*service).addFunc(methodName, m)
+}
+
+// 注销服务：立即停止接受该服务的新请求，并等待正在处理的请求结束后返回。
+// 等待没有时限，在服务自身的方法中调用会因等待自身而死锁，此时应使用 UnregisterContext
+func (server *Server) Unregister(name string) error {
+	return server.UnregisterContext(context.Background(), name)
+}
+
+// 与 Unregister 相同，但 ctx 结束时不再等待正在处理的请求，返回 ctx 的错误，此时服务已被注销。
+// 在服务自身的方法中以该方法收到的 ctx 调用时不等待，直接返回
+func (server *Server) UnregisterContext(ctx context.Context, name string) error {
+	svci, ok := server.serviceMap.LoadAndDelete(name)
+	if !ok {
+		return errors.New("rpc: service not defined: " + name)
+	}
+	svc := svci.(*service)
+	svc.close()
+	log.Printf("rpc server: unregister %s\n", name)
+	if self, _ := ctx.Value(serviceKey{}).(*service); self == svc {
+		return nil
+	}
+	if err := svc.drain(ctx); err != nil {
+		return fmt.Errorf("rpc server: service %s unregistered before in-flight requests finished: %w", name, err)
+	}
+	return nil
+}
+
+// 返回已发布的服务名称，按字典序排列，不含以 "_" 开头的内置服务
+func (server *Server) ServiceNames() []string {
+	var names []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		if name := namei.(string); !strings.HasPrefix(name, "_") {
+			names = append(names, name)
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
 // 在 DefaultServer 中发布接收方的方法。
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// 在 DefaultServer 中以 name 作为服务名发布接收方的方法
+func RegisterName(name string, rcvr interface{}) error { return DefaultServer.RegisterName(name, rcvr) }
+
+// 在 DefaultServer 中将函数发布为 RPC 方法
+func RegisterFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(serviceMethod, fn)
+}
+
+// 在 DefaultServer 中注销服务
+func Unregister(name string) error { return DefaultServer.Unregister(name) }
+
+// 在 DefaultServer 中注销服务，ctx 结束时不再等待正在处理的请求
+func UnregisterContext(ctx context.Context, name string) error {
+	return DefaultServer.UnregisterContext(ctx, name)
+}
+
 const (
 	connected        = "200 Connected to zRPC"
 	defaultRPCPath   = "/_zprc_"
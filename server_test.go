@@ -0,0 +1,330 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Bar int
+
+// 休眠 args.Num1 毫秒后返回
+func (b Bar) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// 启动一个注册了 Foo 和 Bar 的服务端，返回其监听地址
+func startServer(t *testing.T, opt *ServerOption) string {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	server := NewServer(opt)
+	var foo Foo
+	var bar Bar
+	_ = server.Register(&foo)
+	_ = server.Register(&bar)
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func TestServer_MaxConnectionAge(t *testing.T) {
+	addr := startServer(t, &ServerOption{MaxConnectionAge: time.Millisecond * 50})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+	time.Sleep(time.Millisecond * 100)
+	_assert(!client.IsAvailable(), "expect client unavailable after GOAWAY")
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	addr := startServer(t, &ServerOption{MaxConnections: 1})
+	c1, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c1.Close() }()
+	var reply int
+	err = c1.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect first connection accepted, but got %v", err)
+
+	c2, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = c2.Close() }()
+	err = c2.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "too many connections"), "expect rejection, but got %v", err)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	addr := startServer(t, &ServerOption{IdleTimeout: time.Millisecond * 50})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	time.Sleep(time.Millisecond * 150)
+	_assert(!client.IsAvailable(), "expect idle connection closed by server")
+}
+
+func TestServer_Concurrency(t *testing.T) {
+	addr := startServer(t, &ServerOption{
+		MethodConcurrency: map[string]ConcurrencyLimit{"Bar.Sleep": {MaxConcurrent: 1, MaxQueued: 1}},
+	})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 第一个请求执行，第二个请求排队，第三个请求因队列已满被拒绝
+	calls := make([]*Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("Bar.Sleep", &Args{Num1: 100}, new(int), nil)
+		time.Sleep(time.Millisecond * 10)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if i < 2 {
+			_assert(call.Error == nil, "expect call %d succeeded, but got %v", i, call.Error)
+		} else {
+			_assert(errors.Is(call.Error, ErrServerOverloaded), "expect overloaded, but got %v", call.Error)
+		}
+	}
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect unlimited method succeeded, but got %v", err)
+}
+
+// 在方法级排队的请求不占用服务端级的执行名额
+func TestServer_ConcurrencyMethodQueue(t *testing.T) {
+	addr := startServer(t, &ServerOption{
+		Concurrency:       ConcurrencyLimit{MaxConcurrent: 2},
+		MethodConcurrency: map[string]ConcurrencyLimit{"Bar.Sleep": {MaxConcurrent: 1, MaxQueued: 5}},
+	})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 2)
+	for i := range calls {
+		calls[i] = client.Go("Bar.Sleep", &Args{Num1: 100}, new(int), nil)
+	}
+	time.Sleep(time.Millisecond * 20)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect other method served, but got %v", err)
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "expect call %d succeeded, but got %v", i, call.Error)
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	addr := startServer(t, &ServerOption{
+		CallerRateLimit: RateLimit{Rate: 1, Burst: 1},
+		CallerKey:       "tenant",
+	})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	ctx := WithMetadata(context.Background(), map[string]string{"tenant": "a"})
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect first call allowed, but got %v", err)
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	retryAfter, ok := RetryAfter(err)
+	_assert(errors.Is(err, ErrRateLimited) && ok && retryAfter > 0, "expect rate limited with retry-after, but got %v", err)
+	// 其他调用方不受影响
+	ctx = WithMetadata(context.Background(), map[string]string{"tenant": "b"})
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "expect other caller allowed, but got %v", err)
+}
+
+func TestServer_MethodTimeouts(t *testing.T) {
+	server := NewServer(&ServerOption{MethodTimeouts: map[string]time.Duration{
+		"Bar":       time.Second,
+		"Bar.Sleep": time.Millisecond * 50,
+	}})
+	_assert(server.handleTimeout("Bar.Sleep", 0) == time.Millisecond*50, "expect method timeout to apply")
+	_assert(server.handleTimeout("Bar.Sleep", time.Millisecond*10) == time.Millisecond*10, "expect shorter client timeout to apply")
+	_assert(server.handleTimeout("Bar.Other", time.Minute) == time.Second, "expect service timeout to cap client timeout")
+	_assert(server.handleTimeout("Foo.Sum", 0) == 0, "expect no timeout")
+
+	addr := startServer(t, &ServerOption{MethodTimeouts: map[string]time.Duration{"Bar.Sleep": time.Millisecond * 50}})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Bar.Sleep", &Args{Num1: 200}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect handle timeout, but got %v", err)
+}
+
+// 记录同时执行的最大请求数
+type Slow struct {
+	running, peak int64
+}
+
+// 休眠 args.Num1 毫秒后返回
+func (s *Slow) Do(args Args, reply *int) error {
+	n := atomic.AddInt64(&s.running, 1)
+	defer atomic.AddInt64(&s.running, -1)
+	for {
+		peak := atomic.LoadInt64(&s.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&s.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1
+	return nil
+}
+
+// 处理超时后方法仍在执行，名额要等到方法返回后才归还
+func TestServer_ConcurrencyWithTimeout(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer(&ServerOption{
+		MethodConcurrency: map[string]ConcurrencyLimit{"Slow.Do": {MaxConcurrent: 1, MaxQueued: 10}},
+		MethodTimeouts:    map[string]time.Duration{"Slow.Do": time.Millisecond * 20},
+	})
+	var slow Slow
+	_ = server.Register(&slow)
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			_ = client.Call(context.Background(), "Slow.Do", &Args{Num1: 100}, &reply)
+		}()
+	}
+	wg.Wait()
+	// 等待排队的请求执行完毕
+	time.Sleep(time.Millisecond * 300)
+	peak := atomic.LoadInt64(&slow.peak)
+	_assert(peak == 1, "expect at most 1 running handler, but got %d", peak)
+}
+
+func TestServer_RegisterNameAndUnregister(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var bar Bar
+	_ = server.RegisterName("BarV2", &bar)
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 注销时正在处理的请求正常结束
+	var reply int
+	call := client.Go("BarV2.Sleep", &Args{Num1: 100, Num2: 1}, &reply, make(chan *Call, 1))
+	time.Sleep(time.Millisecond * 20)
+	start := time.Now()
+	_assert(server.Unregister("BarV2") == nil, "unregister error")
+	_assert(time.Since(start) > time.Millisecond*50, "expect Unregister to wait for in-flight calls")
+	<-call.Done
+	_assert(call.Error == nil && reply == 101, "expect in-flight call to finish, but got %d, %v", reply, call.Error)
+
+	err = client.Call(context.Background(), "BarV2.Sleep", &Args{Num1: 1}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect unregistered service, but got %v", err)
+	// 请求体被丢弃，后续请求不受影响
+	_ = server.Register(&bar)
+	err = client.Call(context.Background(), "Bar.Sleep", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+	_assert(len(server.ServiceNames()) == 1, "expect 1 service, but got %v", server.ServiceNames())
+}
+
+func TestServer_UnregisterContext(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var bar Bar
+	_ = server.Register(&bar)
+	// 在服务自身的方法中注销不会等待自身而死锁
+	_ = server.RegisterFunc("Self.Stop", func(ctx context.Context, args int, reply *int) error {
+		return server.UnregisterContext(ctx, "Self")
+	})
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Call(ctx, "Self.Stop", 1, &reply)
+	_assert(err == nil, "expect self unregister returned, but got %v", err)
+	_assert(len(server.ServiceNames()) == 1, "expect Self unregistered, but got %v", server.ServiceNames())
+
+	// ctx 结束时不再等待正在处理的请求
+	call := client.Go("Bar.Sleep", &Args{Num1: 200}, &reply, make(chan *Call, 1))
+	time.Sleep(time.Millisecond * 20)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	err = server.UnregisterContext(ctx, "Bar")
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, but got %v", err)
+	_assert(time.Since(start) < time.Millisecond*150, "expect UnregisterContext to stop waiting at the deadline")
+	<-call.Done
+	_assert(call.Error == nil, "expect in-flight call to finish, but got %v", call.Error)
+}
+
+func TestServer_RegisterFunc(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	err := server.RegisterFunc("Math.Add", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	})
+	_assert(err == nil, "register func error: %v", err)
+	err = server.RegisterFunc("Math.Caller", func(ctx context.Context, args Args, reply *string) error {
+		*reply = MetadataFromContext(ctx)["caller"]
+		return nil
+	})
+	_assert(err == nil, "register ctx func error: %v", err)
+	err = server.RegisterFunc("Math.Add", func(args Args, reply *int) error { return nil })
+	_assert(err != nil, "expect duplicate method rejected")
+	err = server.RegisterFunc("Foo.Add", func(args Args, reply *int) error { return nil })
+	_assert(err != nil, "expect name clash with reflected service rejected")
+	err = server.RegisterFunc("Math.Bad", func(args Args) error { return nil })
+	_assert(err != nil && strings.Contains(err.Error(), "has 1 args"), "expect invalid shape rejected, but got %v", err)
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var sum int
+	err = client.Call(context.Background(), "Math.Add", &Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "expect 3, but got %d, %v", sum, err)
+	var caller string
+	ctx := WithMetadata(context.Background(), map[string]string{"caller": "test"})
+	err = client.Call(ctx, "Math.Caller", &Args{}, &caller)
+	_assert(err == nil && caller == "test", "expect metadata in ctx, but got %q, %v", caller, err)
+}
+
+func TestServer_Handler(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var foo FastFoo
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "FastFoo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, but got %d, %v", reply, err)
+}
@@ -1,15 +1,23 @@
 package zrpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"strings"
+	"sync"
 	"sync/atomic"
 )
 
 // 包含一个方法的完整信息
 type methodType struct {
+	name      string         // 方法名
 	method    reflect.Method // 方法本身
+	fn        reflect.Value  // 通过 RegisterFunc 注册的函数，此时 method 为零值
+	hasCtx    bool           // 第一个参数为 context.Context
 	ArgType   reflect.Type   // 第一个参数的类型D
 	ReplyType reflect.Type   // 第二个参数的类型
 	numCalls  uint64         // 用于后续统计方法调用次数
@@ -43,62 +51,244 @@ func (m *methodType) newReplyv() reflect.Value {
 }
 
 type service struct {
-	name   string                 // 映射的结构体的名称
-	typ    reflect.Type           // 结构体的类型
-	rcvr   reflect.Value          // 结构体的实例本身
-	method map[string]*methodType // 存储映射的结构体的所有符合条件的方法
+	name    string                 // 服务名称，默认为映射的结构体的名称
+	typ     reflect.Type           // 结构体的类型
+	rcvr    reflect.Value          // 结构体的实例本身
+	method  map[string]*methodType // 存储映射的结构体的所有符合条件的方法
+	handler Handler                // 结构体实现了 Handler 时不为 nil
+	mu      sync.RWMutex           // 保护 method 和 closed
+	closed  bool                   // 服务已被注销，不再接受新的请求
+	calls   sync.WaitGroup         // 正在处理的请求
 }
 
+// 服务实现 Handler 后，方法的参数创建与调用不再经过反射，适用于调用频繁的方法，
+// 也可以由代码生成器实现。方法仍需符合 RPC 方法的形式，注册时通过反射校验
+type Handler interface {
+	// 新建方法 method 的参数与返回值，二者均需为指针；返回 nil 时该方法仍通过反射调用
+	NewArgs(method string) (args, reply interface{})
+	// 调用方法 method，args 与 reply 为 NewArgs 返回的实例
+	Handle(ctx context.Context, method string, args, reply interface{}) error
+}
+
+var (
+	typeOfHandler = reflect.TypeOf((*Handler)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 // 将任意结构体实例映射为服务
-func newService(rcvr interface{}) *service {
+func newService(rcvr interface{}) (*service, error) {
+	return newNamedService(rcvr, "", false)
+}
+
+// 将任意结构体实例映射为名为 name 的服务，name 为空时使用结构体的名称。
+// strict 为 true 时，任一导出方法不符合条件都返回错误；否则跳过不符合条件的方法，
+// 但至少需要一个符合条件的方法
+func newNamedService(rcvr interface{}, name string, strict bool) (*service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: register nil receiver")
+	}
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
+	s.name = name
+	if s.name == "" {
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+	}
 	s.typ = reflect.TypeOf(rcvr)
+	s.handler, _ = rcvr.(Handler)
 	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+		return nil, fmt.Errorf("rpc server: %q is not a valid service name", s.name)
+	}
+	rejected := s.registerMethods()
+	if strict && len(rejected) > 0 {
+		return nil, fmt.Errorf("rpc server: service %s has invalid methods: %s", s.name, strings.Join(rejected, "; "))
+	}
+	if len(s.method) == 0 {
+		if len(rejected) > 0 {
+			return nil, fmt.Errorf("rpc server: service %s has no suitable methods: %s", s.name, strings.Join(rejected, "; "))
+		}
+		return nil, fmt.Errorf("rpc server: service %s has no exported methods", s.name)
 	}
-	s.registerMethods()
-	return s
+	for _, reason := range rejected {
+		log.Printf("rpc server: skip %s.%s\n", s.name, reason)
+	}
+	return s, nil
 }
 
-// 过滤出了符合条件的方法
-func (s *service) registerMethods() {
+// 过滤出了符合条件的方法，返回被拒绝的方法及原因
+func (s *service) registerMethods() (rejected []string) {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		// 两个入参（反射时为 3 个，第 0 个是自身）
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		// 返回值有且只有 1 个，类型为 error
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
+		// Handler 本身的方法不作为 RPC 方法
+		if s.handler != nil {
+			if _, ok := typeOfHandler.MethodByName(method.Name); ok {
+				continue
+			}
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		// 入参必须为导出或内置类型
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		// 反射时第 0 个入参是自身
+		m, err := newMethodType(method.Type, 1)
+		if err != nil {
+			rejected = append(rejected, method.Name+": "+err.Error())
 			continue
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
+		m.name, m.method = method.Name, method
+		s.method[method.Name] = m
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
+	return rejected
+}
+
+// 新建一个只包含函数的服务，用于 RegisterFunc
+func newFuncService(name string) *service {
+	return &service{name: name, method: make(map[string]*methodType)}
+}
+
+// 将函数映射为方法
+func newFuncType(fn interface{}) (*methodType, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("%T is not a function", fn)
+	}
+	m, err := newMethodType(fv.Type(), 0)
+	if err != nil {
+		return nil, err
+	}
+	m.fn = fv
+	return m, nil
+}
+
+// 向函数服务中添加一个方法
+func (s *service) addFunc(name string, m *methodType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rcvr.IsValid() || s.closed {
+		return errors.New("rpc: service already defined: " + s.name)
+	}
+	if _, dup := s.method[name]; dup {
+		return errors.New("rpc: method already defined: " + s.name + "." + name)
+	}
+	m.name = name
+	s.method[name] = m
+	log.Printf("rpc server: register %s.%s\n", s.name, name)
+	return nil
+}
+
+// 返回名为 name 的方法，不存在时返回 nil
+func (s *service) methodOf(name string) *methodType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.method[name]
+}
+
+// 返回所有方法的副本
+func (s *service) methods() map[string]*methodType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	methods := make(map[string]*methodType, len(s.method))
+	for name, m := range s.method {
+		methods[name] = m
+	}
+	return methods
+}
+
+// 检查方法是否符合 func(args T1, reply *T2) error 或 func(ctx context.Context, args T1, reply *T2) error 的形式，
+// skip 为入参中接收者的个数
+func newMethodType(mType reflect.Type, skip int) (*methodType, error) {
+	m := &methodType{}
+	n := mType.NumIn() - skip
+	// 两个入参，或以 context.Context 开头的三个入参
+	if n == 3 && mType.In(skip) == typeOfContext {
+		m.hasCtx = true
+		skip++
+	} else if n != 2 {
+		return nil, fmt.Errorf("has %d args, want 2 or a context.Context followed by 2", n)
+	}
+	// 返回值有且只有 1 个，类型为 error
+	if mType.NumOut() != 1 {
+		return nil, fmt.Errorf("has %d return values, want 1", mType.NumOut())
+	}
+	if mType.Out(0) != typeOfError {
+		return nil, fmt.Errorf("returns %s, not error", mType.Out(0))
+	}
+	argType, replyType := mType.In(skip), mType.In(skip+1)
+	// 入参必须为导出或内置类型
+	if !isExportedOrBuiltinType(argType) {
+		return nil, fmt.Errorf("arg type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return nil, fmt.Errorf("reply type %s is not exported", replyType)
+	}
+	m.ArgType, m.ReplyType = argType, replyType
+	return m, nil
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-// 通过反射值调用方法
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+// 开始处理一个请求，服务已被注销时返回 false
+func (s *service) begin() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+	s.calls.Add(1)
+	return true
+}
+
+// 结束处理一个请求
+func (s *service) end() {
+	s.calls.Done()
+}
+
+// 停止接受新的请求
+func (s *service) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+// 等待正在处理的请求结束，ctx 先结束时返回其错误
+func (s *service) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 通过 Handler 调用方法，不经过反射
+func (s *service) handle(ctx context.Context, m *methodType, args, reply interface{}) error {
 	atomic.AddUint64(&m.numCalls, 1)
-	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	return s.handler.Handle(ctx, m.name, args, reply)
+}
+
+// 通过反射值调用方法，ctx 仅传给以 context.Context 为第一个参数的方法
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	atomic.AddUint64(&m.numCalls, 1)
+	f, in := m.fn, make([]reflect.Value, 0, 4)
+	if !f.IsValid() {
+		f = m.method.Func
+		in = append(in, s.rcvr)
+	}
+	if m.hasCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	returnValues := f.Call(append(in, argv, replyv))
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
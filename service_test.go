@@ -1,8 +1,11 @@
 package zrpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -30,7 +33,8 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, err := newService(&foo)
+	_assert(err == nil, "new service error: %v", err)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
@@ -38,12 +42,106 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
-}
\ No newline at end of file
+}
+type Mixed int
+
+func (m Mixed) Sum(args Args, reply *int) error { return nil }
+
+// 参数个数错误
+func (m Mixed) One(args Args) error { return nil }
+
+// 返回值不是 error
+func (m Mixed) Count(args Args, reply *int) int { return 0 }
+
+// 返回值不是指针
+func (m Mixed) Value(args Args, reply int) error { return nil }
+
+type unexported int
+
+func (u unexported) Sum(args Args, reply *int) error { return nil }
+
+func TestNewService_Invalid(t *testing.T) {
+	var u unexported
+	_, err := newService(&u)
+	_assert(err != nil && strings.Contains(err.Error(), "not a valid service name"), "expect invalid name, but got %v", err)
+
+	var m Mixed
+	s, err := newService(&m)
+	_assert(err == nil && len(s.method) == 1, "expect invalid methods skipped, but got %v", err)
+
+	_, err = newNamedService(&m, "", true)
+	_assert(err != nil, "expect strict registration to fail")
+	for _, want := range []string{"One: has 1 args", "Count: returns int", "Value: reply type int is not a pointer"} {
+		_assert(strings.Contains(err.Error(), want), "expect %q in %v", want, err)
+	}
+}
+
+// 实现了 Handler 的 Foo，Sum 不经过反射调用
+type FastFoo int
+
+func (f FastFoo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f FastFoo) NewArgs(method string) (args, reply interface{}) {
+	switch method {
+	case "Sum":
+		return new(Args), new(int)
+	}
+	return nil, nil
+}
+
+func (f FastFoo) Handle(ctx context.Context, method string, args, reply interface{}) error {
+	switch method {
+	case "Sum":
+		return f.Sum(*args.(*Args), reply.(*int))
+	}
+	return errors.New("unknown method " + method)
+}
+
+func TestNewService_Handler(t *testing.T) {
+	var foo FastFoo
+	s, err := newNamedService(&foo, "", true)
+	_assert(err == nil, "expect Handler methods excluded from RPC methods, but got %v", err)
+	_assert(s.handler != nil && len(s.method) == 1, "expect handler and 1 method")
+	mType := s.method["Sum"]
+	args, reply := s.handler.NewArgs(mType.name)
+	*args.(*Args) = Args{Num1: 1, Num2: 3}
+	err = s.handle(context.Background(), mType, args, reply)
+	_assert(err == nil && *reply.(*int) == 4 && mType.NumCalls() == 1, "failed to call FastFoo.Sum")
+}
+
+func BenchmarkService_Reflect(b *testing.B) {
+	var foo Foo
+	s, _ := newService(&foo)
+	mType := s.method["Sum"]
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		argv, replyv := mType.newArgv(), mType.newReplyv()
+		argv.Set(reflect.ValueOf(Args{Num1: i, Num2: 1}))
+		_ = s.call(ctx, mType, argv, replyv)
+	}
+}
+
+func BenchmarkService_Handler(b *testing.B) {
+	var foo FastFoo
+	s, _ := newService(&foo)
+	mType := s.method["Sum"]
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		args, reply := s.handler.NewArgs(mType.name)
+		*args.(*Args) = Args{Num1: i, Num2: 1}
+		_ = s.handle(ctx, mType, args, reply)
+	}
+}
@@ -0,0 +1,151 @@
+package xclient
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EWMA 的衰减时间常数，距离上次更新越久，历史延迟的权重越低
+const ewmaDecay = time.Second * 10
+
+// 失败的请求计入的最小延迟，避免快速失败的实例因延迟低而吸引更多流量
+const errorPenalty = time.Second
+
+// 单个服务实例的延迟统计
+type serverStats struct {
+	ewma  float64   // 响应延迟的指数加权移动平均值，单位为纳秒
+	stamp time.Time // 上一次更新 ewma 的时间
+}
+
+// 基于实时负载选择服务实例
+type balancer struct {
+	mu    sync.Mutex
+	r     *rand.Rand
+	index int // RoundRobin 策略下一次选择的位置
+	stats map[string]*serverStats
+}
+
+func newBalancer() *balancer {
+	return &balancer{
+		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats: make(map[string]*serverStats),
+	}
+}
+
+// 记录一次调用的响应延迟
+func (b *balancer) observe(rpcAddr string, rtt time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	s := b.stats[rpcAddr]
+	if s == nil {
+		b.stats[rpcAddr] = &serverStats{ewma: float64(rtt), stamp: now}
+		return
+	}
+	// 按时间间隔计算衰减权重，调用稀疏时新样本的影响更大
+	w := math.Exp(-float64(now.Sub(s.stamp)) / float64(ewmaDecay))
+	s.ewma = s.ewma*w + float64(rtt)*(1-w)
+	s.stamp = now
+}
+
+// 返回实例的 EWMA 延迟，没有统计数据时返回 0
+func (b *balancer) latency(rpcAddr string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.stats[rpcAddr]; s != nil {
+		return time.Duration(s.ewma)
+	}
+	return 0
+}
+
+// 删除实例的统计数据
+func (b *balancer) remove(rpcAddr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.stats, rpcAddr)
+}
+
+// 返回所有实例 EWMA 延迟的中位数，没有统计数据时返回 0
+func (b *balancer) median() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Duration(b.medianLocked())
+}
+
+// 调用方需持有 b.mu
+func (b *balancer) medianLocked() float64 {
+	if len(b.stats) == 0 {
+		return 0
+	}
+	ewmas := make([]float64, 0, len(b.stats))
+	for _, s := range b.stats {
+		ewmas = append(ewmas, s.ewma)
+	}
+	sort.Float64s(ewmas)
+	return ewmas[len(ewmas)/2]
+}
+
+// 综合代价：EWMA 延迟乘以（未完成请求数 + 1）。尚无统计数据的实例按 neutral 延迟计算，
+// 既能尽快得到探测，又不会在首个响应返回前吸引所有并发请求
+func (b *balancer) cost(rpcAddr string, pending int, neutral float64) float64 {
+	ewma := neutral
+	if s := b.stats[rpcAddr]; s != nil {
+		ewma = s.ewma
+	}
+	return ewma * float64(pending+1)
+}
+
+// 根据负载均衡策略，从 servers 中选择一个实例，pending 返回实例当前未完成的请求数
+func (b *balancer) pick(mode SelectMode, servers []string, pending func(rpcAddr string) int) (string, error) {
+	n := len(servers)
+	if n == 0 {
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch mode {
+	case RandomSelect:
+		return servers[b.r.Intn(n)], nil
+	case RoundRobinSelect:
+		s := servers[b.index%n]
+		b.index = (b.index + 1) % n
+		return s, nil
+	case LeastPendingSelect:
+		// 从随机位置开始遍历，使未完成请求数相同的实例被均匀选中
+		start := b.r.Intn(n)
+		best, min := "", math.MaxInt32
+		for i := 0; i < n; i++ {
+			s := servers[(start+i)%n]
+			if p := pending(s); p < min {
+				best, min = s, p
+			}
+		}
+		return best, nil
+	case P2CSelect:
+		if n == 1 {
+			return servers[0], nil
+		}
+		// 随机选取两个不同的实例，取代价更小者
+		i := b.r.Intn(n)
+		j := b.r.Intn(n - 1)
+		if j >= i {
+			j++
+		}
+		a, c := servers[i], servers[j]
+		// 没有统计数据的实例取所有实例延迟的中位数，均没有统计数据时只比较未完成请求数
+		neutral := b.medianLocked()
+		if neutral == 0 {
+			neutral = 1
+		}
+		if b.cost(c, pending(c), neutral) < b.cost(a, pending(a), neutral) {
+			return c, nil
+		}
+		return a, nil
+	default:
+		return "", errors.New("rpc discovery: not supported select mode")
+	}
+}
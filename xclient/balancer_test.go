@@ -0,0 +1,54 @@
+package xclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBalancer_LeastPending(t *testing.T) {
+	b := newBalancer()
+	pending := map[string]int{"tcp@a": 3, "tcp@b": 1, "tcp@c": 2}
+	for i := 0; i < 10; i++ {
+		s, err := b.pick(LeastPendingSelect, []string{"tcp@a", "tcp@b", "tcp@c"}, func(addr string) int { return pending[addr] })
+		if err != nil || s != "tcp@b" {
+			t.Fatalf("expect tcp@b, but got %s, %v", s, err)
+		}
+	}
+}
+
+func TestBalancer_P2C(t *testing.T) {
+	b := newBalancer()
+	b.observe("tcp@fast", time.Millisecond)
+	b.observe("tcp@slow", time.Second)
+	none := func(string) int { return 0 }
+	for i := 0; i < 10; i++ {
+		s, err := b.pick(P2CSelect, []string{"tcp@fast", "tcp@slow"}, none)
+		if err != nil || s != "tcp@fast" {
+			t.Fatalf("expect tcp@fast, but got %s, %v", s, err)
+		}
+	}
+	if _, err := b.pick(P2CSelect, nil, none); err == nil {
+		t.Fatal("expect error when no servers")
+	}
+}
+
+// 没有统计数据的实例按中位数延迟计算代价，未完成请求多时不会被选中
+func TestBalancer_P2CNoStats(t *testing.T) {
+	b := newBalancer()
+	b.observe("tcp@a", time.Millisecond*10)
+	pending := map[string]int{"tcp@a": 1, "tcp@new": 5}
+	for i := 0; i < 10; i++ {
+		s, err := b.pick(P2CSelect, []string{"tcp@a", "tcp@new"}, func(addr string) int { return pending[addr] })
+		if err != nil || s != "tcp@a" {
+			t.Fatalf("expect tcp@a, but got %s, %v", s, err)
+		}
+	}
+	// 都没有统计数据时选择未完成请求更少的实例
+	b = newBalancer()
+	for i := 0; i < 10; i++ {
+		s, err := b.pick(P2CSelect, []string{"tcp@a", "tcp@new"}, func(addr string) int { return pending[addr] })
+		if err != nil || s != "tcp@a" {
+			t.Fatalf("expect tcp@a, but got %s, %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package xclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+	. "zrpc"
+)
+
+// 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭，请求正常通过
+	BreakerOpen                         // 打开，拒绝所有请求
+	BreakerHalfOpen                     // 半开，只允许少量探测请求通过
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrBreakerOpen = errors.New("rpc xclient: circuit breaker is open")
+
+// 熔断器配置，阈值为 0 表示不启用对应的熔断条件
+type BreakerOption struct {
+	ConsecutiveFailures int              // 连续失败次数达到该值时熔断
+	ErrorRate           float64          // 统计窗口内错误率达到该值时熔断，取值 (0, 1]
+	MinRequests         int              // 计算错误率所需的最少请求数
+	Window              time.Duration    // 统计错误率的时间窗口
+	CoolDown            time.Duration    // 熔断后经过该时长进入半开状态
+	HalfOpenRequests    int              // 半开状态下允许同时通过的探测请求数
+	IsFailure           func(error) bool // 判断请求错误是否计为实例故障，为 nil 时使用 IsTransportFailure
+}
+
+// 默认的故障判定：连接错误与超时计为故障，服务端返回的业务错误以及过载、限流等错误不计入，
+// 这些错误说明实例仍在正常响应
+func IsTransportFailure(err error) bool {
+	var se ServerError
+	if errors.As(err, &se) {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code == CodeTimeout
+	}
+	return true
+}
+
+var DefaultBreakerOption = &BreakerOption{
+	ConsecutiveFailures: 5,
+	ErrorRate:           0.5,
+	MinRequests:         20,
+	Window:              time.Second * 10,
+	CoolDown:            time.Second * 5,
+	HalfOpenRequests:    1,
+}
+
+// 单个服务实例的熔断器
+type breaker struct {
+	opt         *BreakerOption
+	mu          sync.Mutex
+	state       BreakerState
+	failures    int       // 连续失败次数
+	requests    int       // 当前窗口内的请求数
+	errs        int       // 当前窗口内的失败数
+	windowStart time.Time // 当前统计窗口的起始时间
+	openedAt    time.Time // 进入打开状态的时间
+	probes      int       // 半开状态下正在进行的探测请求数
+	gen         uint64    // 状态转换的次数，请求的结果只作用于放行该请求时的状态
+}
+
+func newBreaker(opt *BreakerOption) *breaker {
+	return &breaker{opt: opt, windowStart: time.Now()}
+}
+
+// 返回当前状态，打开状态在冷却结束后转为半开，调用方需持有 b.mu
+func (b *breaker) currentState(now time.Time) BreakerState {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.opt.CoolDown {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+		b.gen++
+	}
+	return b.state
+}
+
+// 判断实例是否可被选中，不占用探测名额
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState(time.Now()) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probes < b.opt.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// 在发起请求前调用，半开状态下会占用一个探测名额。返回的 gen 需在请求结束后传给 record 或 release
+func (b *breaker) allow() (gen uint64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState(time.Now()) {
+	case BreakerOpen:
+		return b.gen, false
+	case BreakerHalfOpen:
+		if b.probes >= b.opt.HalfOpenRequests {
+			return b.gen, false
+		}
+		b.probes++
+		return b.gen, true
+	default:
+		return b.gen, true
+	}
+}
+
+// 判断请求错误是否计为故障
+func (b *breaker) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if b.opt.IsFailure != nil {
+		return b.opt.IsFailure(err)
+	}
+	return IsTransportFailure(err)
+}
+
+// 记录一次请求的结果，并据此转换状态。gen 与当前状态不一致时，请求是在之前的状态下放行的，
+// 其结果已过时，例如熔断前放行的请求不能作为半开状态的探测结果
+func (b *breaker) record(gen uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.gen {
+		return
+	}
+	now := time.Now()
+	failed := b.failed(err)
+	if b.state == BreakerHalfOpen {
+		// 探测成功则恢复，失败则重新熔断
+		if !failed {
+			b.reset(BreakerClosed, now)
+		} else {
+			b.trip(now)
+		}
+		return
+	}
+	if b.state == BreakerOpen {
+		return
+	}
+	if b.opt.Window > 0 && now.Sub(b.windowStart) >= b.opt.Window {
+		b.requests, b.errs, b.windowStart = 0, 0, now
+	}
+	b.requests++
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.errs++
+	if b.opt.ConsecutiveFailures > 0 && b.failures >= b.opt.ConsecutiveFailures {
+		b.trip(now)
+		return
+	}
+	if b.opt.ErrorRate > 0 && b.requests >= b.opt.MinRequests &&
+		float64(b.errs)/float64(b.requests) >= b.opt.ErrorRate {
+		b.trip(now)
+	}
+}
+
+// 请求未产生有效结果（如被调用方主动取消）时调用，归还占用的探测名额
+func (b *breaker) release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen == b.gen && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// 进入打开状态，调用方需持有 b.mu
+func (b *breaker) trip(now time.Time) {
+	b.reset(BreakerOpen, now)
+	b.openedAt = now
+}
+
+// 切换状态并清空统计数据，调用方需持有 b.mu
+func (b *breaker) reset(state BreakerState, now time.Time) {
+	b.state = state
+	b.gen++
+	b.failures, b.requests, b.errs, b.probes = 0, 0, 0, 0
+	b.windowStart = now
+}
+
+// 返回熔断器当前状态，供监控使用
+func (b *breaker) status() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState(time.Now())
+}
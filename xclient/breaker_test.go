@@ -0,0 +1,82 @@
+package xclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"zrpc"
+)
+
+// 放行一个请求并记录其结果
+func try(b *breaker, err error) {
+	if gen, ok := b.allow(); ok {
+		b.record(gen, err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(&BreakerOption{ConsecutiveFailures: 2, CoolDown: time.Millisecond * 50, HalfOpenRequests: 1})
+	failed := errors.New("failed")
+	try(b, failed)
+	if b.status() != BreakerClosed {
+		t.Fatalf("expect closed, but got %s", b.status())
+	}
+	try(b, failed)
+	if _, ok := b.allow(); b.status() != BreakerOpen || ok {
+		t.Fatalf("expect open, but got %s", b.status())
+	}
+	time.Sleep(time.Millisecond * 60)
+	gen, ok := b.allow()
+	if !ok || b.status() != BreakerHalfOpen {
+		t.Fatalf("expect half-open with one probe, but got %s", b.status())
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("expect only one probe in half-open state")
+	}
+	b.record(gen, nil)
+	if b.status() != BreakerClosed {
+		t.Fatalf("expect closed after successful probe, but got %s", b.status())
+	}
+}
+
+// 熔断前放行的请求的结果不影响半开状态
+func TestBreaker_StaleResult(t *testing.T) {
+	b := newBreaker(&BreakerOption{ConsecutiveFailures: 1, CoolDown: time.Millisecond * 50, HalfOpenRequests: 1})
+	stale, _ := b.allow()
+	try(b, errors.New("failed"))
+	time.Sleep(time.Millisecond * 60)
+	probe, ok := b.allow()
+	if !ok || b.status() != BreakerHalfOpen {
+		t.Fatalf("expect half-open, but got %s", b.status())
+	}
+	b.record(stale, nil)
+	if b.status() != BreakerHalfOpen {
+		t.Fatalf("expect stale success ignored, but got %s", b.status())
+	}
+	b.record(probe, errors.New("failed"))
+	if b.status() != BreakerOpen {
+		t.Fatalf("expect probe failure to reopen, but got %s", b.status())
+	}
+}
+
+// 服务端返回的业务错误、过载与限流不计为故障
+func TestBreaker_IsFailure(t *testing.T) {
+	b := newBreaker(&BreakerOption{ConsecutiveFailures: 1, CoolDown: time.Minute})
+	for _, err := range []error{zrpc.ServerError("invalid args"), zrpc.ErrServerOverloaded, zrpc.ErrRateLimited} {
+		try(b, err)
+		if b.status() != BreakerClosed {
+			t.Fatalf("expect %v not counted as failure", err)
+		}
+	}
+	try(b, zrpc.ErrHandleTimeout)
+	if b.status() != BreakerOpen {
+		t.Fatalf("expect handle timeout counted as failure, but got %s", b.status())
+	}
+
+	b = newBreaker(&BreakerOption{ConsecutiveFailures: 1, CoolDown: time.Minute, IsFailure: func(error) bool { return true }})
+	try(b, zrpc.ServerError("invalid args"))
+	if b.status() != BreakerOpen {
+		t.Fatalf("expect custom classifier applied, but got %s", b.status())
+	}
+}
@@ -13,8 +13,15 @@ type SelectMode int
 const (
 	RandomSelect     SelectMode = iota // 随机选择
 	RoundRobinSelect                   // RoundRobin 策略
+	LeastPendingSelect                 // 选择未完成请求最少的实例，由 XClient 根据缓存的 Client 完成选择
+	P2CSelect                          // 随机选取两个实例，选择 EWMA 延迟与未完成请求数综合代价更小者
 )
 
+// 判断负载均衡策略是否依赖实例的实时负载
+func (mode SelectMode) loadAware() bool {
+	return mode == LeastPendingSelect || mode == P2CSelect
+}
+
 // 服务发现所需的基本接口
 type Discovery interface {
 	Refresh() error                      //从注册中心更新服务列表
@@ -23,7 +30,17 @@ type Discovery interface {
 	GetAll() ([]string, error)           //返回所有的服务实例
 }
 
+// 服务实例列表发生变化时的回调，added 为新增的实例，removed 为移除的实例
+type WatchFunc func(added, removed []string)
+
+// 支持订阅服务实例列表变化的服务发现，XClient 借此关闭已下线实例的连接
+type WatchDiscovery interface {
+	// 订阅服务实例列表的变化，返回的函数用于取消订阅
+	Watch(f WatchFunc) (cancel func())
+}
+
 var _ Discovery = (*MultiServersDiscovery)(nil)
+var _ WatchDiscovery = (*MultiServersDiscovery)(nil)
 
 // 不需要注册中心，服务列表由手工维护的服务发现的结构体
 type MultiServersDiscovery struct {
@@ -31,6 +48,8 @@ type MultiServersDiscovery struct {
 	mu      sync.RWMutex 
 	servers []string     
 	index   int          // 记录 Robin 算法选中的位置
+	watchers    map[uint64]WatchFunc // 服务实例列表变化时调用的回调
+	nextWatcher uint64               // 下一个订阅者的编号
 }
 
 func (d *MultiServersDiscovery) Refresh() error {
@@ -38,10 +57,65 @@ func (d *MultiServersDiscovery) Refresh() error {
 }
 
 func (d *MultiServersDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	added, removed := d.setServers(servers)
+	d.mu.Unlock()
+	d.notify(added, removed)
+	return nil
+}
+
+// 订阅服务实例列表的变化，返回的函数用于取消订阅
+func (d *MultiServersDiscovery) Watch(f WatchFunc) (cancel func()) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if d.watchers == nil {
+		d.watchers = make(map[uint64]WatchFunc)
+	}
+	id := d.nextWatcher
+	d.nextWatcher++
+	d.watchers[id] = f
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.watchers, id)
+	}
+}
+
+// 替换服务实例列表，返回新增与移除的实例，调用方需持有 d.mu
+func (d *MultiServersDiscovery) setServers(servers []string) (added, removed []string) {
+	old := make(map[string]bool, len(d.servers))
+	for _, s := range d.servers {
+		old[s] = true
+	}
+	for _, s := range servers {
+		if !old[s] {
+			added = append(added, s)
+		}
+		delete(old, s)
+	}
+	for _, s := range d.servers {
+		if old[s] {
+			removed = append(removed, s)
+		}
+	}
 	d.servers = servers
-	return nil
+	return
+}
+
+// 通知订阅者服务实例列表的变化，回调可能访问服务发现，因此不能在持有 d.mu 时调用
+func (d *MultiServersDiscovery) notify(added, removed []string) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	d.mu.RLock()
+	watchers := make([]WatchFunc, 0, len(d.watchers))
+	for _, f := range d.watchers {
+		watchers = append(watchers, f)
+	}
+	d.mu.RUnlock()
+	for _, f := range watchers {
+		f(added, removed)
+	}
 }
 
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
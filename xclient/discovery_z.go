@@ -19,36 +19,49 @@ const defaultUpdateTimeout = time.Second * 10
 // 更新服务列表
 func (d *ZRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.servers = servers
+	added, removed := d.setServers(servers)
 	d.lastUpdate = time.Now()
+	d.mu.Unlock()
+	d.notify(added, removed)
 	return nil
 }
 
 // 超时重新获取服务列表
 func (d *ZRegistryDiscovery) Refresh() error {
+	added, removed, err := d.refresh()
+	if err != nil {
+		return err
+	}
+	d.notify(added, removed)
+	return nil
+}
+
+// 从注册中心获取服务列表，返回新增与移除的实例
+func (d *ZRegistryDiscovery) refresh() (added, removed []string, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	// 若未超时
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
-		return nil
+		return nil, nil, nil
 	}
 	log.Println("rpc registry: refresh servers from registry", d.registry)
 	resp, err := http.Get(d.registry)
 	if err != nil {
 		log.Println("rpc registry refresh err:", err)
-		return err
+		return nil, nil, err
 	}
+	_ = resp.Body.Close()
 	// 从 HTTP 响应报头的　X-Zrpc-Servers　字段获取服务列表
-	servers := strings.Split(resp.Header.Get("X-Zrpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
+	header := strings.Split(resp.Header.Get("X-Zrpc-Servers"), ",")
+	servers := make([]string, 0, len(header))
+	for _, server := range header {
 		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+			servers = append(servers, strings.TrimSpace(server))
 		}
 	}
+	added, removed = d.setServers(servers)
 	d.lastUpdate = time.Now()
-	return nil
+	return added, removed, nil
 }
 
 func (d *ZRegistryDiscovery) Get(mode SelectMode) (string, error) {
@@ -0,0 +1,147 @@
+package xclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 离群检测配置，阈值为 0 表示不启用对应的检测条件
+type OutlierOption struct {
+	ConsecutiveErrors  int           // 连续失败次数达到该值时驱逐实例
+	LatencyFactor      float64       // EWMA 延迟超过所有实例中位数的该倍数时驱逐实例
+	MinRequests        int           // 按延迟判断前，实例需积累的最少请求数
+	BaseEjectionTime   time.Duration // 基础驱逐时长，第 n 次驱逐的时长为其 n 倍
+	MaxEjectionTime    time.Duration // 驱逐时长的上限
+	MaxEjectionPercent int           // 同时被驱逐的实例占全部实例的百分比上限
+}
+
+var DefaultOutlierOption = &OutlierOption{
+	ConsecutiveErrors:  5,
+	LatencyFactor:      3,
+	MinRequests:        10,
+	BaseEjectionTime:   time.Second * 30,
+	MaxEjectionTime:    time.Minute * 5,
+	MaxEjectionPercent: 10,
+}
+
+// 单个服务实例的离群统计
+type hostStats struct {
+	failures     int       // 连续失败次数
+	requests     int       // 累计请求数
+	ejections    int       // 累计驱逐次数，决定下一次驱逐的时长
+	ejectedUntil time.Time // 驱逐的截止时间
+}
+
+// 被动离群检测，根据调用结果驱逐表现异常的实例
+type outlierDetector struct {
+	opt   *OutlierOption
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+}
+
+func newOutlierDetector(opt *OutlierOption) *outlierDetector {
+	return &outlierDetector{opt: opt, hosts: make(map[string]*hostStats)}
+}
+
+// 判断实例当前是否处于驱逐状态
+func (o *outlierDetector) ejected(rpcAddr string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h := o.hosts[rpcAddr]
+	return h != nil && time.Now().Before(h.ejectedUntil)
+}
+
+// 返回当前处于驱逐状态的实例
+func (o *outlierDetector) ejectedHosts() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	var hosts []string
+	for rpcAddr, h := range o.hosts {
+		if now.Before(h.ejectedUntil) {
+			hosts = append(hosts, rpcAddr)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// 删除实例的离群统计，实例从服务发现中移除时调用
+func (o *outlierDetector) remove(rpcAddr string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.hosts, rpcAddr)
+}
+
+// 记录一次调用结果，latency 为实例的 EWMA 延迟，median 为所有实例 EWMA 延迟的中位数，
+// total 返回服务实例总数，仅在需要驱逐时调用
+func (o *outlierDetector) record(rpcAddr string, err error, latency, median time.Duration, total func() int) {
+	if !o.observe(rpcAddr, err, latency, median) {
+		return
+	}
+	// total 可能访问服务发现，不在持有 o.mu 时调用
+	n := total()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	h := o.hosts[rpcAddr]
+	if now.Before(h.ejectedUntil) || !o.canEject(now, n) {
+		return
+	}
+	h.ejections++
+	d := o.opt.BaseEjectionTime * time.Duration(h.ejections)
+	if o.opt.MaxEjectionTime > 0 && d > o.opt.MaxEjectionTime {
+		d = o.opt.MaxEjectionTime
+	}
+	h.ejectedUntil = now.Add(d)
+	h.failures, h.requests = 0, 0
+}
+
+// 更新实例的统计数据，返回实例是否为离群实例
+func (o *outlierDetector) observe(rpcAddr string, err error, latency, median time.Duration) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	h := o.hosts[rpcAddr]
+	if h == nil {
+		h = &hostStats{}
+		o.hosts[rpcAddr] = h
+	}
+	if now.Before(h.ejectedUntil) {
+		return false
+	}
+	h.requests++
+	if err != nil {
+		h.failures++
+	} else {
+		h.failures = 0
+		// 驱逐结束后持续健康超过 MaxEjectionTime，则重置驱逐次数
+		if h.ejections > 0 && now.Sub(h.ejectedUntil) > o.opt.MaxEjectionTime {
+			h.ejections = 0
+		}
+	}
+	if o.opt.ConsecutiveErrors > 0 && h.failures >= o.opt.ConsecutiveErrors {
+		return true
+	}
+	return o.opt.LatencyFactor > 0 && h.requests >= o.opt.MinRequests && median > 0 &&
+		float64(latency) > float64(median)*o.opt.LatencyFactor
+}
+
+// 判断驱逐一个新实例后是否超过驱逐上限，至少保留一个实例不被驱逐，调用方需持有 o.mu
+func (o *outlierDetector) canEject(now time.Time, total int) bool {
+	max := total * o.opt.MaxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	if max > total-1 {
+		max = total - 1
+	}
+	n := 0
+	for _, h := range o.hosts {
+		if now.Before(h.ejectedUntil) {
+			n++
+		}
+	}
+	return n < max
+}
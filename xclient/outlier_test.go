@@ -0,0 +1,41 @@
+package xclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector_MaxEjection(t *testing.T) {
+	o := newOutlierDetector(&OutlierOption{
+		ConsecutiveErrors:  1,
+		BaseEjectionTime:   time.Second,
+		MaxEjectionTime:    time.Second * 3,
+		MaxEjectionPercent: 50,
+	})
+	total := func() int { return 2 }
+	failed := errors.New("failed")
+	o.record("tcp@a", failed, 0, 0, total)
+	o.record("tcp@b", failed, 0, 0, total)
+	if !o.ejected("tcp@a") {
+		t.Fatal("expect tcp@a to be ejected")
+	}
+	if o.ejected("tcp@b") {
+		t.Fatal("expect tcp@b to be kept, at most one of two servers can be ejected")
+	}
+}
+
+func TestOutlierDetector_Latency(t *testing.T) {
+	o := newOutlierDetector(&OutlierOption{
+		LatencyFactor:      2,
+		MinRequests:        1,
+		BaseEjectionTime:   time.Second,
+		MaxEjectionPercent: 50,
+	})
+	total := func() int { return 3 }
+	o.record("tcp@fast", nil, time.Millisecond, time.Millisecond, total)
+	o.record("tcp@slow", nil, time.Millisecond*10, time.Millisecond, total)
+	if o.ejected("tcp@fast") || !o.ejected("tcp@slow") {
+		t.Fatalf("expect only tcp@slow to be ejected, but got %v", o.ejectedHosts())
+	}
+}
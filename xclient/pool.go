@@ -0,0 +1,208 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	. "zrpc"
+)
+
+// 连接池配置
+type PoolOption struct {
+	Size           int           // 每个服务实例的最大连接数
+	IdleTimeout    time.Duration // 没有未完成请求的连接空闲超过该时长后被关闭，0 表示不回收
+	DialFailureTTL time.Duration // 建立连接失败后，在该时长内直接返回上次的错误，0 表示不缓存
+}
+
+var DefaultPoolOption = &PoolOption{
+	Size:           1,
+	DialFailureTTL: time.Second,
+}
+
+var errPoolClosed = errors.New("rpc xclient: client pool is closed")
+
+// 连接池的统计信息
+type PoolStats struct {
+	Conns     int    // 当前连接数
+	Pending   int    // 所有连接上未完成的请求数
+	Dials     uint64 // 累计新建的连接数
+	Evictions uint64 // 累计因空闲或不可用而关闭的连接数
+}
+
+// 连接池中的一个连接
+type pooledClient struct {
+	*Client
+	lastUsed time.Time // 最后一次被取出的时间
+}
+
+// 一次正在进行的建立连接过程，并发的调用方共享其结果
+type dialCall struct {
+	done   chan struct{} // 建立连接结束后关闭
+	client *Client
+	err    error
+}
+
+// 单个服务实例的连接池
+type clientPool struct {
+	rpcAddr  string
+	mu       sync.Mutex
+	clients  []*pooledClient
+	dialing  *dialCall // 正在进行的建立连接过程
+	failedAt time.Time // 上一次建立连接失败的时间
+	lastErr  error     // 上一次建立连接失败的错误
+	closed   bool
+	stats    PoolStats
+}
+
+func newClientPool(rpcAddr string) *clientPool {
+	return &clientPool{rpcAddr: rpcAddr}
+}
+
+// 取出一个连接：优先选择未完成请求最少的连接，所有连接都繁忙且未达到上限时新建连接。
+// 建立连接在锁外进行，同一实例上并发的调用方共享同一次建立连接的结果
+func (p *clientPool) get(ctx context.Context, opt *PoolOption, dial func(rpcAddr string) (*Client, error)) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errPoolClosed
+	}
+	p.removeUnavailable()
+	var best *pooledClient
+	min := 0
+	for _, c := range p.clients {
+		if n := c.NumPending(); best == nil || n < min {
+			best, min = c, n
+		}
+	}
+	grow := best == nil || (min > 0 && len(p.clients) < opt.Size)
+	if !grow {
+		best.lastUsed = time.Now()
+		p.mu.Unlock()
+		return best.Client, nil
+	}
+	d := p.dialing
+	if d == nil {
+		// 建立连接刚失败过，直接返回上次的错误，避免反复连接不可达的实例
+		if opt.DialFailureTTL > 0 && time.Since(p.failedAt) < opt.DialFailureTTL {
+			p.mu.Unlock()
+			if best != nil {
+				return best.Client, nil
+			}
+			return nil, p.lastErr
+		}
+		d = &dialCall{done: make(chan struct{})}
+		p.dialing = d
+		go p.dial(d, dial)
+	}
+	// 已有可用连接时不等待新连接建立完成
+	if best != nil {
+		best.lastUsed = time.Now()
+		p.mu.Unlock()
+		return best.Client, nil
+	}
+	p.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return nil, errors.New("rpc xclient: dial failed: " + ctx.Err().Error())
+	case <-d.done:
+		return d.client, d.err
+	}
+}
+
+// 建立一个新连接并放入连接池，结束后通知所有等待的调用方
+func (p *clientPool) dial(d *dialCall, dial func(rpcAddr string) (*Client, error)) {
+	client, err := dial(p.rpcAddr)
+	p.mu.Lock()
+	switch {
+	case err != nil:
+		p.failedAt, p.lastErr = time.Now(), err
+	case p.closed:
+		// 连接池在建立连接期间被关闭
+		_ = client.Close()
+		client, err = nil, errPoolClosed
+	default:
+		p.clients = append(p.clients, &pooledClient{Client: client, lastUsed: time.Now()})
+		p.stats.Dials++
+	}
+	p.dialing = nil
+	p.mu.Unlock()
+	d.client, d.err = client, err
+	close(d.done)
+}
+
+// 移除不可用的连接，连接在未完成的请求结束后关闭，调用方需持有 p.mu
+func (p *clientPool) removeUnavailable() {
+	clients := p.clients[:0]
+	for _, c := range p.clients {
+		if c.IsAvailable() {
+			clients = append(clients, c)
+			continue
+		}
+		c.Drain()
+		p.stats.Evictions++
+	}
+	p.clients = clients
+}
+
+// 关闭空闲超过 timeout 的连接
+func (p *clientPool) evictIdle(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	clients := p.clients[:0]
+	for _, c := range p.clients {
+		if c.NumPending() > 0 || now.Sub(c.lastUsed) < timeout {
+			clients = append(clients, c)
+			continue
+		}
+		_ = c.Close()
+		p.stats.Evictions++
+	}
+	p.clients = clients
+}
+
+// 所有连接上未完成的请求数
+func (p *clientPool) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pendingLocked()
+}
+
+func (p *clientPool) pendingLocked() int {
+	n := 0
+	for _, c := range p.clients {
+		n += c.NumPending()
+	}
+	return n
+}
+
+// 不再取出连接，所有连接在未完成的请求结束后关闭
+func (p *clientPool) drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, c := range p.clients {
+		c.Drain()
+	}
+	p.clients = nil
+}
+
+func (p *clientPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, c := range p.clients {
+		_ = c.Close()
+	}
+	p.clients = nil
+}
+
+func (p *clientPool) statistics() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Conns = len(p.clients)
+	stats.Pending = p.pendingLocked()
+	return stats
+}
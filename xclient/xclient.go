@@ -2,78 +2,446 @@ package xclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	. "zrpc"
 	"io"
+	"log"
+	"math/rand"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
 	d       Discovery
 	mode    SelectMode
 	opt     *Option
+	b       *balancer  // 记录各实例的延迟，供基于负载的策略使用
 	mu      sync.Mutex 
-	clients map[string]*Client
+	clients map[string]*clientPool // 每个服务实例一个连接池
+	popt     *PoolOption         // 连接池配置
+	stopEvict chan struct{}       // 关闭时停止当前回收空闲连接的协程
+	done     chan struct{}       // XClient 关闭时关闭该通道
+	prewarm  bool                // 是否为新增的服务实例提前建立连接
+	bopt     *BreakerOption      // 熔断器配置，为 nil 时不启用熔断
+	breakers map[string]*breaker // 每个服务实例一个熔断器
+	od       *outlierDetector    // 离群检测，为 nil 时不启用
+	forkWins map[string]uint64   // Fork 调用中各实例最先成功返回的次数
+	unwatch  func()              // 取消订阅服务实例列表的变化
+	gen      uint64              // 服务实例被移除的次数，用于判断新建连接池期间实例是否被移除
 }
 
 var _ io.Closer = (*XClient)(nil)
+var _ Caller = (*XClient)(nil)
 
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{
-		d:       d, 
-		mode:    mode, 
-		opt:     opt, 
-		clients: make(map[string]*Client),
+	xc := &XClient{
+		d:        d, 
+		mode:     mode, 
+		opt:      opt, 
+		b:        newBalancer(),
+		clients:  make(map[string]*clientPool),
+		popt:     DefaultPoolOption,
+		done:     make(chan struct{}),
+		breakers: make(map[string]*breaker),
+		forkWins: make(map[string]uint64),
 	}
+	// 若服务发现支持订阅，则在实例下线时关闭对应的连接
+	if wd, ok := d.(WatchDiscovery); ok {
+		xc.unwatch = wd.Watch(xc.onServersChange)
+	}
+	return xc
+}
+
+// 设置是否为服务发现中新增的服务实例提前建立连接，需在发起调用前设置
+func (xc *XClient) SetPrewarm(prewarm bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.prewarm = prewarm
+}
+
+// 服务实例列表变化时调用：移除的实例在未完成的请求结束后关闭连接，新增的实例按需提前建立连接
+func (xc *XClient) onServersChange(added, removed []string) {
+	xc.mu.Lock()
+	pools := make([]*clientPool, 0, len(removed))
+	for _, rpcAddr := range removed {
+		if pool, ok := xc.clients[rpcAddr]; ok {
+			pools = append(pools, pool)
+			delete(xc.clients, rpcAddr)
+		}
+	}
+	if len(removed) > 0 {
+		xc.gen++
+	}
+	prewarm := xc.prewarm
+	xc.mu.Unlock()
+	for _, pool := range pools {
+		pool.drain()
+	}
+	for _, rpcAddr := range removed {
+		xc.forget(rpcAddr)
+	}
+	if !prewarm {
+		return
+	}
+	for _, rpcAddr := range added {
+		go func(rpcAddr string) {
+			if _, _, err := xc.dial(context.Background(), rpcAddr); err != nil {
+				log.Println("rpc xclient: prewarm error:", err)
+			}
+		}(rpcAddr)
+	}
+}
+
+// 为每个服务实例启用熔断器，opt 为 nil 时使用 DefaultBreakerOption，需在发起调用前设置
+func (xc *XClient) SetBreaker(opt *BreakerOption) {
+	if opt == nil {
+		opt = DefaultBreakerOption
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.bopt = opt
+	xc.breakers = make(map[string]*breaker)
+}
+
+// 返回服务实例的熔断器，未启用熔断时返回 nil
+func (xc *XClient) breaker(rpcAddr string) *breaker {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.bopt == nil {
+		return nil
+	}
+	br := xc.breakers[rpcAddr]
+	if br == nil {
+		br = newBreaker(xc.bopt)
+		xc.breakers[rpcAddr] = br
+	}
+	return br
+}
+
+// 返回各服务实例熔断器的当前状态，供监控使用
+func (xc *XClient) BreakerStates() map[string]BreakerState {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	states := make(map[string]BreakerState, len(xc.breakers))
+	for rpcAddr, br := range xc.breakers {
+		states[rpcAddr] = br.status()
+	}
+	return states
+}
+
+// 启用被动离群检测，opt 为 nil 时使用 DefaultOutlierOption，需在发起调用前设置
+func (xc *XClient) SetOutlierDetection(opt *OutlierOption) {
+	if opt == nil {
+		opt = DefaultOutlierOption
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.od = newOutlierDetector(opt)
+}
+
+func (xc *XClient) outlierDetector() *outlierDetector {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.od
+}
+
+// 返回当前被离群检测驱逐的服务实例
+func (xc *XClient) EjectedServers() []string {
+	if od := xc.outlierDetector(); od != nil {
+		return od.ejectedHosts()
+	}
+	return nil
+}
+
+// 返回服务实例总数，获取失败时返回 0
+func (xc *XClient) numServers() int {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return 0
+	}
+	return len(servers)
+}
+
+// 设置每个服务实例的连接池，opt 为 nil 时使用 DefaultPoolOption，需在发起调用前设置
+func (xc *XClient) SetPool(opt *PoolOption) {
+	if opt == nil {
+		opt = DefaultPoolOption
+	}
+	// 复制配置，不修改调用方的 opt
+	popt := *opt
+	if popt.Size < 1 {
+		popt.Size = 1
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.popt = &popt
+	// 停止之前的回收协程，始终只有一个协程回收空闲连接
+	if xc.stopEvict != nil {
+		close(xc.stopEvict)
+		xc.stopEvict = nil
+	}
+	if popt.IdleTimeout > 0 {
+		xc.stopEvict = make(chan struct{})
+		go xc.evictIdle(popt.IdleTimeout, xc.stopEvict)
+	}
+}
+
+// 定期关闭空闲的连接，直到 XClient 关闭或 stop 被关闭
+func (xc *XClient) evictIdle(timeout time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-xc.done:
+			return
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		for _, pool := range xc.pools() {
+			pool.evictIdle(timeout)
+		}
+	}
+}
+
+// 返回所有服务实例的连接池
+func (xc *XClient) pools() map[string]*clientPool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	pools := make(map[string]*clientPool, len(xc.clients))
+	for rpcAddr, pool := range xc.clients {
+		pools[rpcAddr] = pool
+	}
+	return pools
+}
+
+// 返回各服务实例连接池的统计信息
+func (xc *XClient) PoolStats() map[string]PoolStats {
+	pools := xc.pools()
+	stats := make(map[string]PoolStats, len(pools))
+	for rpcAddr, pool := range pools {
+		stats[rpcAddr] = pool.statistics()
+	}
+	return stats
 }
 
 func (xc *XClient) Close() error {
+	// 取消订阅后，已关闭的 XClient 不再收到服务实例列表的变化
+	if xc.unwatch != nil {
+		xc.unwatch()
+	}
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		_ = client.Close()
+	select {
+	case <-xc.done:
+	default:
+		close(xc.done)
+	}
+	for key, pool := range xc.clients {
+		pool.close()
 		delete(xc.clients, key)
 	}
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+// 删除实例的熔断器与统计数据，实例从服务发现中移除时调用
+func (xc *XClient) forget(rpcAddr string) {
 	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client, ok := xc.clients[rpcAddr]
-	// 检查 xc.clients 是否有缓存的 Client，如果有，检查是否是可用状态，如果是则返回缓存的 Client，如果不可用，则从缓存中删除
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
-	}
-	// 创建新的 Client，缓存并返回
-	if client == nil {
-		var err error
-		client, err = XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
+	delete(xc.breakers, rpcAddr)
+	delete(xc.forkWins, rpcAddr)
+	od := xc.od
+	xc.mu.Unlock()
+	xc.b.remove(rpcAddr)
+	if od != nil {
+		od.remove(rpcAddr)
+	}
+}
+
+// 判断实例是否在服务发现的实例列表中
+func (xc *XClient) isMember(rpcAddr string) bool {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return false
+	}
+	for _, s := range servers {
+		if s == rpcAddr {
+			return true
 		}
-		xc.clients[rpcAddr] = client
 	}
-	return client, nil
+	return false
 }
 
-func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+// 返回服务实例的连接池，只为服务发现中的实例新建连接池，避免为已移除的实例重新建立连接。
+// GetAll 可能刷新实例列表并回调 onServersChange，因此不能在持有 xc.mu 时调用
+func (xc *XClient) pool(rpcAddr string) (*clientPool, error) {
+	for {
+		xc.mu.Lock()
+		select {
+		case <-xc.done:
+			xc.mu.Unlock()
+			return nil, ErrShutdown
+		default:
+		}
+		if pool, ok := xc.clients[rpcAddr]; ok {
+			xc.mu.Unlock()
+			return pool, nil
+		}
+		gen := xc.gen
+		xc.mu.Unlock()
+		if !xc.isMember(rpcAddr) {
+			return nil, fmt.Errorf("rpc xclient: server %s is not in discovery", rpcAddr)
+		}
+		xc.mu.Lock()
+		// 检查期间没有实例被移除，此后的移除会由 onServersChange 关闭新建的连接池
+		if xc.gen == gen {
+			pool, ok := xc.clients[rpcAddr]
+			if !ok {
+				pool = newClientPool(rpcAddr)
+				xc.clients[rpcAddr] = pool
+			}
+			xc.mu.Unlock()
+			return pool, nil
+		}
+		xc.mu.Unlock()
+	}
+}
+
+// 判断 pool 是否仍是实例当前的连接池，实例被移除后返回 false
+func (xc *XClient) current(rpcAddr string, pool *clientPool) bool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return pool != nil && xc.clients[rpcAddr] == pool
+}
+
+// 从服务实例的连接池中取出可用的 Client，必要时新建连接。xc.mu 只保护连接池的查找，
+// 建立连接不会阻塞对其他实例的调用
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*clientPool, *Client, error) {
+	pool, err := xc.pool(rpcAddr)
 	if err != nil {
+		return nil, nil, err
+	}
+	xc.mu.Lock()
+	popt := xc.popt
+	xc.mu.Unlock()
+	client, err := pool.get(ctx, popt, func(rpcAddr string) (*Client, error) {
+		return XDial(rpcAddr, xc.opt)
+	})
+	return pool, client, err
+}
+
+func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	br := xc.breaker(rpcAddr)
+	var gen uint64
+	if br != nil {
+		var ok bool
+		if gen, ok = br.allow(); !ok {
+			return ErrBreakerOpen
+		}
+	}
+	var rtt time.Duration
+	pool, client, err := xc.dial(ctx, rpcAddr)
+	if err == nil {
+		start := time.Now()
+		err = client.Call(ctx, serviceMethod, args, reply)
+		rtt = time.Since(start)
+	}
+	// 调用方主动取消的请求不计入实例的统计，Fork 中落败的请求延迟接近胜出者，不能作为样本
+	if err != nil && ctx.Err() == context.Canceled {
+		if br != nil {
+			br.release(gen)
+		}
 		return err
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	// 失败的请求（包括建立连接失败）按惩罚延迟计入
+	if err != nil && rtt < errorPenalty {
+		rtt = errorPenalty
+	}
+	xc.b.observe(rpcAddr, rtt)
+	if br != nil {
+		br.record(gen, err)
+	}
+	if od := xc.outlierDetector(); od != nil {
+		od.record(rpcAddr, err, xc.b.latency(rpcAddr), xc.b.median(), xc.numServers)
+	}
+	// 实例在调用期间被移除时，onServersChange 可能已先删除了统计数据，此处再删除一次
+	if !xc.current(rpcAddr, pool) {
+		xc.forget(rpcAddr)
+	}
+	return err
+}
+
+// 返回缓存的 Client 上尚未完成的请求数，没有缓存时返回 0
+func (xc *XClient) pending(rpcAddr string) int {
+	xc.mu.Lock()
+	pool := xc.clients[rpcAddr]
+	xc.mu.Unlock()
+	if pool == nil {
+		return 0
+	}
+	return pool.pending()
+}
+
+// 返回实例响应延迟的 EWMA 值，没有统计数据时返回 0
+func (xc *XClient) Latency(rpcAddr string) time.Duration {
+	return xc.b.latency(rpcAddr)
+}
+
+// 根据负载均衡策略选择一个服务实例。启用熔断或离群检测时，由 XClient 跳过自身判定为不可用的实例后完成选择，
+// 不影响共享同一服务发现的其他 XClient；基于负载的策略由 XClient 根据缓存的 Client 统计信息完成选择。
+// tried 中的实例已被熔断器拒绝，不再参与选择
+func (xc *XClient) selectServer(tried map[string]bool) (string, error) {
+	if !xc.mode.loadAware() && len(tried) == 0 && !xc.filtering() {
+		return xc.d.Get(xc.mode)
+	}
+	all, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	servers := make([]string, 0, len(all))
+	open := false // 是否有实例因熔断被跳过
+	for _, s := range all {
+		if br := xc.breaker(s); tried[s] || (br != nil && !br.ready()) {
+			open = true
+			continue
+		}
+		if od := xc.outlierDetector(); od != nil && od.ejected(s) {
+			continue
+		}
+		servers = append(servers, s)
+	}
+	if len(servers) == 0 && open {
+		return "", ErrBreakerOpen
+	}
+	return xc.b.pick(xc.mode, servers, xc.pending)
+}
+
+// 是否启用了熔断或离群检测，启用时选择实例前需跳过不可用的实例
+func (xc *XClient) filtering() bool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.bopt != nil || xc.od != nil
 }
 
 // 调用命名函数，待其完成，返回错误状态，xc 将选择一个合适的服务器
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
-	if err != nil {
-		return err
+	var tried map[string]bool
+	for {
+		rpcAddr, err := xc.selectServer(tried)
+		if err != nil {
+			return err
+		}
+		// 半开的熔断器只放行有限的探测请求，选中后未获得名额时改选其他实例
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		if err != ErrBreakerOpen {
+			return err
+		}
+		if tried == nil {
+			tried = make(map[string]bool)
+		}
+		tried[rpcAddr] = true
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
 // Broadcast 将请求广播到所有的服务实例，如果任意一个实例发生错误，则返回其中一个错误；如果调用成功，则返回其中一个的结果
@@ -87,6 +455,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -112,4 +481,158 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	// 确保所有并发请求都顺利完成
 	wg.Wait()
 	return e
-}
\ No newline at end of file
+}
+// Fork 将请求发送到所有的服务实例，返回最先成功的结果并取消其余请求；若全部失败，则返回其中一个错误
+func (xc *XClient) Fork(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return xc.ForkN(ctx, 0, serviceMethod, args, reply)
+}
+
+// ForkN 与 Fork 相同，但只发送到随机选取的 n 个服务实例，n <= 0 或超过实例数时发送到所有实例
+func (xc *XClient) ForkN(ctx context.Context, n int, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return errors.New("rpc discovery: no available servers")
+	}
+	if n > 0 && n < len(servers) {
+		rand.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+		servers = servers[:n]
+	}
+	var mu sync.Mutex
+	replyDone := false
+	errs := make(chan error, len(servers))
+	ctx, cancel := context.WithCancel(ctx)
+	// 返回时取消其余未完成的请求
+	defer cancel()
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			mu.Lock()
+			// 只有最先成功的请求写入 reply
+			if err == nil && !replyDone {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				}
+				replyDone = true
+				xc.mu.Lock()
+				// 不为已移除的实例计数
+				if _, ok := xc.clients[rpcAddr]; ok {
+					xc.forkWins[rpcAddr]++
+				}
+				xc.mu.Unlock()
+			}
+			mu.Unlock()
+			errs <- err
+		}(rpcAddr)
+	}
+	var e error
+	for range servers {
+		if err := <-errs; err != nil {
+			e = err
+			continue
+		}
+		return nil
+	}
+	return e
+}
+
+// 返回 Fork 调用中各实例最先成功返回的次数
+func (xc *XClient) ForkWins() map[string]uint64 {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	wins := make(map[string]uint64, len(xc.forkWins))
+	for rpcAddr, n := range xc.forkWins {
+		wins[rpcAddr] = n
+	}
+	return wins
+}
+
+// 分散-聚合调用的完成条件
+type GatherMode int
+
+const (
+	GatherAll    GatherMode = iota // 等待所有实例返回
+	GatherFirstN                   // 获得 N 个成功结果后立即返回
+	GatherQuorum                   // 获得超过半数的成功结果后立即返回
+)
+
+// 分散-聚合调用的配置
+type GatherOption struct {
+	Mode GatherMode
+	N    int // GatherFirstN 模式下需要的成功结果数
+}
+
+// 单个服务实例的调用结果
+type GatherResult struct {
+	Addr  string      // 服务实例地址
+	Reply interface{} // 与 reply 参数同类型的返回值，调用失败时为 nil
+	Err   error       // 调用错误，被提前取消的调用为取消错误
+}
+
+// Gather 将请求发送到所有的服务实例，按实例顺序返回每个实例的结果。reply 仅用于确定返回值类型，
+// 满足 opt 的完成条件后取消其余未完成的请求；若无法满足完成条件，同时返回结果与错误
+func (xc *XClient) Gather(ctx context.Context, serviceMethod string, args, reply interface{}, opt *GatherOption) ([]GatherResult, error) {
+	if opt == nil {
+		opt = &GatherOption{Mode: GatherAll}
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("rpc discovery: no available servers")
+	}
+	// 计算完成条件所需的成功结果数
+	need := len(servers)
+	switch opt.Mode {
+	case GatherFirstN:
+		if opt.N <= 0 || opt.N > len(servers) {
+			return nil, fmt.Errorf("rpc xclient: gather needs %d successes, but only %d servers", opt.N, len(servers))
+		}
+		need = opt.N
+	case GatherQuorum:
+		need = len(servers)/2 + 1
+	}
+	results := make([]GatherResult, len(servers))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, failed := 0, 0
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i, rpcAddr := range servers {
+		wg.Add(1)
+		go func(i int, rpcAddr string) {
+			defer wg.Done()
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			result := GatherResult{Addr: rpcAddr, Err: err}
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				result.Reply = clonedReply
+				succeeded++
+			} else {
+				failed++
+			}
+			results[i] = result
+			// 已满足完成条件，或剩余请求全部成功也无法满足时，取消未完成的请求
+			if opt.Mode != GatherAll && (succeeded >= need || len(servers)-failed < need) {
+				cancel()
+			}
+		}(i, rpcAddr)
+	}
+	wg.Wait()
+	if succeeded < need {
+		return results, fmt.Errorf("rpc xclient: gather got %d successes, expect %d", succeeded, need)
+	}
+	return results, nil
+}
@@ -0,0 +1,388 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zrpc"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// 启动一个注册了 Foo 的服务端，返回其 XDial 地址
+func startServer(t *testing.T) string {
+	var foo Foo
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	server := zrpc.NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Gather(t *testing.T) {
+	servers := []string{startServer(t), startServer(t), "tcp@127.0.0.1:1"}
+	xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	results, err := xc.Gather(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, nil)
+	if err == nil || len(results) != 3 {
+		t.Fatalf("expect an error for unreachable server and 3 results, but got %v, %d", err, len(results))
+	}
+	for _, r := range results[:2] {
+		if r.Err != nil || *r.Reply.(*int) != 3 {
+			t.Fatalf("expect 3 from %s, but got %v, %v", r.Addr, r.Reply, r.Err)
+		}
+	}
+	if results[2].Err == nil {
+		t.Fatal("expect error from unreachable server")
+	}
+
+	results, err = xc.Gather(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, &GatherOption{Mode: GatherQuorum})
+	if err != nil {
+		t.Fatalf("expect quorum reached, but got %v", err)
+	}
+}
+
+func TestXClient_Fork(t *testing.T) {
+	s1, s2 := startServer(t), startServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{s1, s2}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	if err := xc.Fork(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, but got %d, %v", reply, err)
+	}
+	wins := xc.ForkWins()
+	if wins[s1]+wins[s2] != 1 {
+		t.Fatalf("expect exactly one win, but got %v", wins)
+	}
+}
+
+// Fork 中被取消的请求不计入延迟统计
+func TestXClient_ForkCanceledLatency(t *testing.T) {
+	// 接受连接但从不响应的实例
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+	fast, stuck := startServer(t), "tcp@"+l.Addr().String()
+	xc := NewXClient(NewMultiServerDiscovery([]string{fast, stuck}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	if err := xc.Fork(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, but got %d, %v", reply, err)
+	}
+	// 等待被取消的请求返回
+	time.Sleep(time.Millisecond * 50)
+	if xc.Latency(fast) == 0 {
+		t.Fatal("expect latency of the winner recorded")
+	}
+	if d := xc.Latency(stuck); d != 0 {
+		t.Fatalf("expect no latency recorded for the canceled call, but got %v", d)
+	}
+}
+
+// 总是返回同一个实例、不支持过滤的服务发现
+type fixedDiscovery struct {
+	*MultiServersDiscovery
+	first string
+}
+
+func (d fixedDiscovery) Get(mode SelectMode) (string, error) {
+	return d.first, nil
+}
+
+// 选中的实例被熔断器拒绝时改选其他实例
+func TestXClient_BreakerReselect(t *testing.T) {
+	s1, s2 := startServer(t), startServer(t)
+	d := fixedDiscovery{NewMultiServerDiscovery([]string{s1, s2}), s1}
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(&BreakerOption{ConsecutiveFailures: 1, CoolDown: time.Minute})
+	try(xc.breaker(s1), errors.New("failed"))
+
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect call served by %s, but got %d, %v", s2, reply, err)
+	}
+	try(xc.breaker(s2), errors.New("failed"))
+	if err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != ErrBreakerOpen {
+		t.Fatalf("expect ErrBreakerOpen, but got %v", err)
+	}
+}
+
+// 熔断器只影响所属的 XClient，共享同一服务发现的其他 XClient 仍可选中该实例
+func TestXClient_BreakerNotShared(t *testing.T) {
+	s1, s2 := startServer(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{s1, s2})
+	a := NewXClient(d, RoundRobinSelect, nil)
+	a.SetBreaker(&BreakerOption{ConsecutiveFailures: 1, CoolDown: time.Minute})
+	try(a.breaker(s1), errors.New("failed"))
+	var reply int
+	for i := 0; i < 4; i++ {
+		_ = a.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+	if _, ok := a.PoolStats()[s1]; ok {
+		t.Fatalf("expect %s skipped by the client whose breaker is open", s1)
+	}
+	_ = a.Close()
+
+	b := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = b.Close() }()
+	for i := 0; i < 4; i++ {
+		if err := b.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := b.PoolStats(); stats[s1].Dials != 1 || stats[s2].Dials != 1 {
+		t.Fatalf("expect both servers used by another client, but got %+v", stats)
+	}
+}
+
+// 快速失败的实例按惩罚延迟计入，不会比正常的实例代价更低
+func TestXClient_ErrorPenalty(t *testing.T) {
+	good, bad := startServer(t), "tcp@127.0.0.1:1"
+	xc := NewXClient(NewMultiServerDiscovery([]string{good, bad}), P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	if err := xc.call(good, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.call(bad, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err == nil {
+		t.Fatal("expect error from unreachable server")
+	}
+	if xc.Latency(bad) < errorPenalty || xc.Latency(good) >= xc.Latency(bad) {
+		t.Fatalf("expect failed server penalized, but got %v for good and %v for bad", xc.Latency(good), xc.Latency(bad))
+	}
+}
+
+func TestXClient_Pool(t *testing.T) {
+	addr := startServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetPool(&PoolOption{Size: 2, IdleTimeout: time.Millisecond * 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			_ = xc.Call(context.Background(), "Foo.Sleep", Args{Num1: 50}, &reply)
+		}()
+		time.Sleep(time.Millisecond * 5)
+	}
+	wg.Wait()
+	if stats := xc.PoolStats()[addr]; stats.Conns != 2 || stats.Dials != 2 {
+		t.Fatalf("expect 2 connections, but got %+v", stats)
+	}
+	time.Sleep(time.Millisecond * 250)
+	if stats := xc.PoolStats()[addr]; stats.Conns != 0 || stats.Evictions != 2 {
+		t.Fatalf("expect idle connections evicted, but got %+v", stats)
+	}
+}
+
+func TestXClient_SetPool(t *testing.T) {
+	xc := NewXClient(NewMultiServerDiscovery(nil), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	opt := &PoolOption{IdleTimeout: time.Second}
+	xc.SetPool(opt)
+	if opt.Size != 0 || xc.popt.Size != 1 {
+		t.Fatalf("expect option copied with size 1, but got %+v and %+v", opt, xc.popt)
+	}
+	stop := xc.stopEvict
+	xc.SetPool(opt)
+	select {
+	case <-stop:
+	default:
+		t.Fatal("expect previous eviction loop stopped")
+	}
+	xc.SetPool(nil)
+	if xc.stopEvict != nil {
+		t.Fatal("expect no eviction loop without idle timeout")
+	}
+}
+
+// 接受连接但从不回复 CONNECT 的 HTTP 实例，返回其 XDial 地址与累计接受的连接数
+func startBlackhole(t *testing.T) (string, *int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	accepted := new(int64)
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(accepted, 1)
+			conns = append(conns, conn)
+		}
+	}()
+	return "http@" + l.Addr().String(), accepted
+}
+
+func TestXClient_SharedDial(t *testing.T) {
+	addr := startServer(t)
+	blackhole, accepted := startBlackhole(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr, blackhole}), RoundRobinSelect, &zrpc.Option{ConnectTimeout: time.Millisecond * 500})
+	defer func() { _ = xc.Close() }()
+	xc.SetPool(&PoolOption{Size: 1, DialFailureTTL: time.Minute})
+
+	// 并发调用阻塞的实例，共享同一次建立连接
+	var blocked sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		blocked.Add(1)
+		go func() {
+			defer blocked.Done()
+			var reply int
+			if err := xc.call(blackhole, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err == nil {
+				t.Error("expect connect timeout from blackhole")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	// 阻塞的建立连接过程不影响对其他实例的调用
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if err := xc.call(addr, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d > time.Millisecond*200 {
+		t.Fatalf("expect calls to healthy server finish promptly, but took %v", d)
+	}
+	if stats := xc.PoolStats()[addr]; stats.Dials != 1 {
+		t.Fatalf("expect concurrent callers to share one dial, but got %+v", stats)
+	}
+
+	blocked.Wait()
+	if n := atomic.LoadInt64(accepted); n != 1 {
+		t.Fatalf("expect one dial to blackhole, but got %d", n)
+	}
+	// DialFailureTTL 内不再重新建立连接
+	start = time.Now()
+	var reply int
+	if err := xc.call(blackhole, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err == nil {
+		t.Fatal("expect cached dial error")
+	}
+	if d := time.Since(start); d > time.Millisecond*100 {
+		t.Fatalf("expect cached dial error returned promptly, but took %v", d)
+	}
+	time.Sleep(time.Millisecond * 20)
+	if n := atomic.LoadInt64(accepted); n != 1 {
+		t.Fatalf("expect no redial within DialFailureTTL, but got %d dials", n)
+	}
+}
+
+func TestXClient_ServersChange(t *testing.T) {
+	s1, s2 := startServer(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{s1})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetPrewarm(true)
+	xc.SetOutlierDetection(nil)
+
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.Fork(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Update([]string{s2})
+	time.Sleep(time.Millisecond * 50)
+	stats := xc.PoolStats()
+	if _, ok := stats[s1]; ok {
+		t.Fatalf("expect pool of removed server %s closed, but got %+v", s1, stats)
+	}
+	if stats[s2].Conns != 1 {
+		t.Fatalf("expect prewarmed connection to %s, but got %+v", s2, stats)
+	}
+	// 移除的实例的统计数据被一并删除
+	if _, ok := xc.ForkWins()[s1]; ok {
+		t.Fatalf("expect fork wins of removed server %s pruned", s1)
+	}
+	if _, ok := xc.od.hosts[s1]; ok || xc.Latency(s1) != 0 {
+		t.Fatalf("expect stats of removed server %s pruned", s1)
+	}
+	// 在移除前选中该实例的调用不会为其重新建立连接或记录统计数据
+	xc.SetBreaker(nil)
+	if err := xc.call(s1, context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err == nil {
+		t.Fatalf("expect call to removed server %s rejected", s1)
+	}
+	if _, ok := xc.PoolStats()[s1]; ok {
+		t.Fatalf("expect no pool for removed server %s", s1)
+	}
+	if _, ok := xc.BreakerStates()[s1]; ok || xc.Latency(s1) != 0 || len(xc.EjectedServers()) != 0 {
+		t.Fatalf("expect no stats recorded for removed server %s", s1)
+	}
+	if _, ok := xc.od.hosts[s1]; ok {
+		t.Fatalf("expect no outlier stats recorded for removed server %s", s1)
+	}
+	// 关闭后取消订阅
+	_ = xc.Close()
+	if n := len(d.watchers); n != 0 {
+		t.Fatalf("expect watcher removed on close, but got %d", n)
+	}
+}
+
+func TestXClient_Invoke(t *testing.T) {
+	xc := NewXClient(NewMultiServerDiscovery([]string{startServer(t)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	sum, err := zrpc.Invoke[Args, int](context.Background(), xc, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	if err != nil || sum != 3 {
+		t.Fatalf("expect 3, but got %d, %v", sum, err)
+	}
+}
@@ -0,0 +1,104 @@
+package zrpctest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zrpc"
+)
+
+// 进程内连接使用的传输协议，地址形如 mem@zrpctest-1
+const Network = "mem"
+
+var (
+	errListenerClosed = errors.New("zrpctest: listener closed")
+
+	listeners sync.Map // 地址到 *Listener 的映射
+	nextID    int64
+	register  sync.Once
+)
+
+// 进程内的监听器，通过 net.Pipe 建立连接，不占用端口
+type Listener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// 新建一个进程内的监听器，zrpc.XDial 可通过 RPCAddr 连接到该监听器
+func Listen() *Listener {
+	register.Do(func() { zrpc.RegisterDialer(Network, dial) })
+	l := &Listener{
+		name:  fmt.Sprintf("zrpctest-%d", atomic.AddInt64(&nextID, 1)),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	listeners.Store(l.name, l)
+	return l
+}
+
+// 返回形如 mem@zrpctest-1 的地址，可用于 zrpc.XDial 与 xclient 的服务发现
+func (l *Listener) RPCAddr() string {
+	return Network + "@" + l.name
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// 关闭监听器，已建立的连接不受影响
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		listeners.Delete(l.name)
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return addr(l.name)
+}
+
+type addr string
+
+func (a addr) Network() string { return Network }
+func (a addr) String() string  { return string(a) }
+
+// 连接到名为 name 的监听器，在 timeout 内未被 Accept 时返回错误
+func dial(name string, timeout time.Duration) (net.Conn, error) {
+	li, ok := listeners.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("zrpctest: dial %s: no such listener", name)
+	}
+	l := li.(*Listener)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, errListenerClosed
+	case <-expired:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, fmt.Errorf("zrpctest: dial %s: timeout", name)
+	}
+}
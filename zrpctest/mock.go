@@ -0,0 +1,165 @@
+package zrpctest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"zrpc"
+)
+
+// 模拟服务端，用于替代测试中无法直接运行的远端服务。
+// 通过 Method 声明方法的参数与返回值类型，再为其设置按参数匹配的响应：
+//
+//	mock := zrpctest.NewMock(t, nil)
+//	sum := zrpctest.Method[Args, int](mock, "Foo.Sum")
+//	sum.When(Args{Num1: 1, Num2: 2}).Return(3)
+//	sum.Any().ReturnError(errors.New("boom")).After(time.Second)
+//
+// 方法通过 RegisterFunc 发布，因此适用于任何已注册的编码
+type Mock struct {
+	*Server
+	t     testing.TB
+	mu    sync.Mutex
+	calls []RecordedCall
+}
+
+// 模拟服务端收到的一次调用
+type RecordedCall struct {
+	ServiceMethod string
+	Args          interface{}
+	Metadata      map[string]string
+	Time          time.Time
+}
+
+// 启动一个进程内的模拟服务端，opt 为 nil 时使用默认配置
+func NewMock(t testing.TB, opt *zrpc.ServerOption) *Mock {
+	t.Helper()
+	return &Mock{Server: NewServer(t, opt), t: t}
+}
+
+// 按收到的顺序返回所有调用，包括没有匹配响应的调用
+func (m *Mock) Calls() []RecordedCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RecordedCall(nil), m.calls...)
+}
+
+func (m *Mock) record(serviceMethod string, args interface{}, md map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, RecordedCall{ServiceMethod: serviceMethod, Args: args, Metadata: md, Time: time.Now()})
+}
+
+// 模拟服务端上的一个方法，参数类型为 Req，返回值类型为 Resp
+type MockMethod[Req, Resp any] struct {
+	mock          *Mock
+	serviceMethod string
+	mu            sync.Mutex
+	stubs         []*Stub[Req, Resp]
+	calls         []Req
+}
+
+// 在模拟服务端上发布形如 "Service.Method" 的方法，发布失败时测试立即失败
+func Method[Req, Resp any](m *Mock, serviceMethod string) *MockMethod[Req, Resp] {
+	m.t.Helper()
+	mm := &MockMethod[Req, Resp]{mock: m, serviceMethod: serviceMethod}
+	if err := m.RegisterFunc(serviceMethod, mm.handle); err != nil {
+		m.t.Fatal(err)
+	}
+	return mm
+}
+
+// 参数与 args 深度相等时匹配
+func (mm *MockMethod[Req, Resp]) When(args Req) *Stub[Req, Resp] {
+	return mm.Match(func(a Req) bool { return reflect.DeepEqual(a, args) })
+}
+
+// match 返回 true 时匹配
+func (mm *MockMethod[Req, Resp]) Match(match func(Req) bool) *Stub[Req, Resp] {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	s := &Stub[Req, Resp]{method: mm, match: match}
+	mm.stubs = append(mm.stubs, s)
+	return s
+}
+
+// 匹配任意参数
+func (mm *MockMethod[Req, Resp]) Any() *Stub[Req, Resp] {
+	return mm.Match(func(Req) bool { return true })
+}
+
+// 按收到的顺序返回该方法的所有调用参数
+func (mm *MockMethod[Req, Resp]) Calls() []Req {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Req(nil), mm.calls...)
+}
+
+// 按设置的顺序返回第一个匹配的响应，没有匹配的响应时返回错误
+func (mm *MockMethod[Req, Resp]) handle(ctx context.Context, args Req, reply *Resp) error {
+	mm.mock.record(mm.serviceMethod, args, zrpc.MetadataFromContext(ctx))
+	mm.mu.Lock()
+	mm.calls = append(mm.calls, args)
+	var stub Stub[Req, Resp]
+	found := false
+	for _, s := range mm.stubs {
+		if s.match(args) {
+			stub, found = *s, true
+			break
+		}
+	}
+	mm.mu.Unlock()
+	if !found {
+		return fmt.Errorf("zrpctest: unexpected call %s(%+v)", mm.serviceMethod, args)
+	}
+	if stub.delay > 0 {
+		t := time.NewTimer(stub.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if stub.err != nil {
+		return stub.err
+	}
+	*reply = stub.reply
+	return nil
+}
+
+// 一条匹配规则及其响应
+type Stub[Req, Resp any] struct {
+	method *MockMethod[Req, Resp]
+	match  func(Req) bool
+	reply  Resp
+	err    error
+	delay  time.Duration
+}
+
+// 匹配时返回 reply
+func (s *Stub[Req, Resp]) Return(reply Resp) *Stub[Req, Resp] {
+	s.method.mu.Lock()
+	defer s.method.mu.Unlock()
+	s.reply, s.err = reply, nil
+	return s
+}
+
+// 匹配时返回错误 err，*zrpc.Error 的错误码会传递给调用方
+func (s *Stub[Req, Resp]) ReturnError(err error) *Stub[Req, Resp] {
+	s.method.mu.Lock()
+	defer s.method.mu.Unlock()
+	s.err = err
+	return s
+}
+
+// 匹配时等待 d 后再响应，服务端处理超时后提前返回
+func (s *Stub[Req, Resp]) After(d time.Duration) *Stub[Req, Resp] {
+	s.method.mu.Lock()
+	defer s.method.mu.Unlock()
+	s.delay = d
+	return s
+}
@@ -0,0 +1,60 @@
+package zrpctest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"zrpc"
+	"zrpc/codec"
+)
+
+func TestMock(t *testing.T) {
+	mock := NewMock(t, nil)
+	sum := Method[Args, int](mock, "Foo.Sum")
+	sum.When(Args{Num1: 1, Num2: 2}).Return(3)
+	sum.Match(func(a Args) bool { return a.Num1 < 0 }).ReturnError(zrpc.ErrRateLimited)
+
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		client := mock.Client(t, &zrpc.Option{CodecType: ct})
+		ctx := zrpc.WithMetadata(context.Background(), map[string]string{"codec": string(ct)})
+		reply, err := zrpc.Invoke[Args, int](ctx, client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+		if err != nil || reply != 3 {
+			t.Fatalf("%s: expect 3, but got %d, %v", ct, reply, err)
+		}
+		_, err = zrpc.Invoke[Args, int](ctx, client, "Foo.Sum", Args{Num1: -1})
+		if !errors.Is(err, zrpc.ErrRateLimited) {
+			t.Fatalf("%s: expect rate limited, but got %v", ct, err)
+		}
+		_, err = zrpc.Invoke[Args, int](ctx, client, "Foo.Sum", Args{Num1: 7})
+		if err == nil || !strings.Contains(err.Error(), "unexpected call") {
+			t.Fatalf("%s: expect unexpected call error, but got %v", ct, err)
+		}
+	}
+
+	calls := mock.Calls()
+	if len(calls) != 6 || calls[0].ServiceMethod != "Foo.Sum" || calls[0].Metadata["codec"] != string(codec.GobType) {
+		t.Fatalf("unexpected recorded calls %+v", calls)
+	}
+	if args := sum.Calls(); len(args) != 6 || args[1] != (Args{Num1: -1}) {
+		t.Fatalf("unexpected recorded args %+v", args)
+	}
+}
+
+func TestMock_After(t *testing.T) {
+	mock := NewMock(t, nil)
+	Method[Args, int](mock, "Foo.Sum").Any().Return(5).After(time.Millisecond * 100)
+	client := mock.Client(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := zrpc.Invoke[Args, int](ctx, client, "Foo.Sum", Args{}); err == nil {
+		t.Fatal("expect timeout before delayed reply")
+	}
+	start := time.Now()
+	reply, err := zrpc.Invoke[Args, int](context.Background(), client, "Foo.Sum", Args{})
+	if err != nil || reply != 5 || time.Since(start) < time.Millisecond*100 {
+		t.Fatalf("expect delayed 5, but got %d, %v after %s", reply, err, time.Since(start))
+	}
+}
@@ -0,0 +1,63 @@
+// Package zrpctest 提供测试 zrpc 服务与调用方的辅助函数：服务端运行在进程内的监听器上，
+// 不占用端口，所有资源在测试结束时通过 t.Cleanup 自动释放。
+package zrpctest
+
+import (
+	"testing"
+	"zrpc"
+	"zrpc/xclient"
+)
+
+// 运行在进程内监听器上的服务端
+type Server struct {
+	*zrpc.Server
+	Listener *Listener
+}
+
+// 启动一个进程内的服务端，并发布 rcvrs 的方法，opt 为 nil 时使用默认配置
+func NewServer(t testing.TB, opt *zrpc.ServerOption, rcvrs ...interface{}) *Server {
+	t.Helper()
+	s := &Server{Server: zrpc.NewServer(opt), Listener: Listen()}
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go s.Accept(s.Listener)
+	t.Cleanup(func() { _ = s.Listener.Close() })
+	return s
+}
+
+// 返回形如 mem@zrpctest-1 的地址
+func (s *Server) RPCAddr() string {
+	return s.Listener.RPCAddr()
+}
+
+// 返回一个连接到该服务端的客户端
+func (s *Server) Client(t testing.TB, opts ...*zrpc.Option) *zrpc.Client {
+	t.Helper()
+	client, err := zrpc.XDial(s.RPCAddr(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// 返回一个只包含该服务端的 XClient
+func (s *Server) XClient(t testing.TB, opt *zrpc.Option) *xclient.XClient {
+	t.Helper()
+	return NewXClient(t, xclient.RoundRobinSelect, opt, s)
+}
+
+// 返回一个以 servers 作为静态服务发现的 XClient
+func NewXClient(t testing.TB, mode xclient.SelectMode, opt *zrpc.Option, servers ...*Server) *xclient.XClient {
+	t.Helper()
+	addrs := make([]string, 0, len(servers))
+	for _, s := range servers {
+		addrs = append(addrs, s.RPCAddr())
+	}
+	xc := xclient.NewXClient(xclient.NewMultiServerDiscovery(addrs), mode, opt)
+	t.Cleanup(func() { _ = xc.Close() })
+	return xc
+}
@@ -0,0 +1,49 @@
+package zrpctest
+
+import (
+	"context"
+	"testing"
+	"zrpc"
+	"zrpc/codec"
+	"zrpc/xclient"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestServer_Client(t *testing.T) {
+	var foo Foo
+	s := NewServer(t, nil, &foo)
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		client := s.Client(t, &zrpc.Option{CodecType: ct})
+		var reply int
+		if err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("%s: expect 3, but got %d, %v", ct, reply, err)
+		}
+	}
+}
+
+func TestNewXClient(t *testing.T) {
+	var foo Foo
+	servers := []*Server{NewServer(t, nil, &foo), NewServer(t, nil, &foo)}
+	xc := NewXClient(t, xclient.RoundRobinSelect, nil, servers...)
+	var reply int
+	results, err := xc.Gather(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply, nil)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expect 2 results, but got %d, %v", len(results), err)
+	}
+}
+
+func TestListener_Close(t *testing.T) {
+	l := Listen()
+	_ = l.Close()
+	if _, err := zrpc.XDial(l.RPCAddr()); err == nil {
+		t.Fatal("expect dial to a closed listener to fail")
+	}
+}